@@ -18,10 +18,12 @@ package archive
 
 import (
 	"archive/tar"
+	"context"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 )
 
@@ -63,20 +65,41 @@ func fillGo18FileTypeBits(mode int64, fi os.FileInfo) int64 {
 }
 
 func Tar(srcPath, dst string) error {
+	return TarContext(context.Background(), srcPath, dst)
+}
+
+// TarContext is Tar bound to ctx, the archive is removed when ctx is
+// cancelled before it is complete.
+func TarContext(ctx context.Context, srcPath, dst string) error {
 	f, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
 
-	return TarStream(f, srcPath)
+	err = TarStreamContext(ctx, f, srcPath)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(dst)
+	}
+
+	return err
 }
 
 func TarStream(dst io.Writer, srcPath string) error {
+	return TarStreamContext(context.Background(), dst, srcPath)
+}
+
+func TarStreamContext(ctx context.Context, dst io.Writer, srcPath string) error {
 	tarWriter := tar.NewWriter(dst)
 	defer tarWriter.Close()
 
 	return filepath.Walk(srcPath, func(filePath string, fi os.FileInfo, err error) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
 		headers, err := tar.FileInfoHeader(fi, filePath)
 		if err != nil {
 			return err
@@ -111,7 +134,7 @@ func TarStream(dst io.Writer, srcPath string) error {
 			if err != nil {
 				return err
 			}
-			if _, err := io.Copy(tarWriter, data); err != nil {
+			if _, err := io.Copy(tarWriter, &contextReader{ctx, data}); err != nil {
 				data.Close()
 				return err
 			}
@@ -121,6 +144,23 @@ func TarStream(dst io.Writer, srcPath string) error {
 	})
 }
 
+// contextReader stops reading once ctx is done.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	return r.r.Read(p)
+}
+
+// Untar extracts the archive into dst. Entries leaving dst are refused:
+// paths outside of it, symlinks pointing outside of it and entries written
+// through a symlink. The missing parent directories of a file are created.
 func Untar(dst string, src io.Reader) error {
 	tarReader := tar.NewReader(src)
 	for {
@@ -133,20 +173,41 @@ func Untar(dst string, src io.Reader) error {
 		}
 
 		extractPath := filepath.Join(dst, header.Name)
+		if !within(dst, extractPath) || extractPath == filepath.Clean(dst) {
+			return fmt.Errorf("extract tar: %s is outside of the destination", header.Name)
+		}
+		if err := noSymlinks(dst, extractPath); err != nil {
+			return fmt.Errorf("extract tar: %s: %s", header.Name, err)
+		}
+
 		switch header.Typeflag {
 		case tar.TypeDir:
-			if err := os.MkdirAll(extractPath, os.FileMode(header.Mode)); err != nil && os.IsNotExist(err) {
+			if err := os.MkdirAll(extractPath, os.FileMode(header.Mode)); err != nil {
 				return err
 			}
 		case tar.TypeReg:
-			if err := untarCreateFile(extractPath, tarReader); err != nil && os.IsNotExist(err) {
+			if err := os.MkdirAll(filepath.Dir(extractPath), os.ModePerm); err != nil {
+				return err
+			}
+			if err := untarCreateFile(extractPath, tarReader); err != nil {
 				return err
 			}
 			if err := os.Chmod(extractPath, os.FileMode(header.Mode)); err != nil {
 				return err
 			}
 		case tar.TypeSymlink:
-			if err := os.Symlink(header.Linkname, extractPath); err != nil && os.IsNotExist(err) {
+			target := header.Linkname
+			if !filepath.IsAbs(target) {
+				target = filepath.Join(filepath.Dir(extractPath), target)
+			}
+			if filepath.IsAbs(header.Linkname) || !within(dst, target) {
+				return fmt.Errorf("extract tar: %s links to %s outside of the destination", header.Name, header.Linkname)
+			}
+
+			if err := os.MkdirAll(filepath.Dir(extractPath), os.ModePerm); err != nil {
+				return err
+			}
+			if err := os.Symlink(header.Linkname, extractPath); err != nil {
 				return err
 			}
 		default:
@@ -155,6 +216,33 @@ func Untar(dst string, src io.Reader) error {
 	}
 }
 
+// within reports whether path is dst or inside of it.
+func within(dst, path string) bool {
+	rel, err := filepath.Rel(dst, path)
+
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// noSymlinks makes sure neither path nor one of its parents below dst is a
+// symlink, which would redirect the write.
+func noSymlinks(dst, path string) error {
+	dst = filepath.Clean(dst)
+	for p := path; p != dst && len(p) > len(dst); p = filepath.Dir(p) {
+		fi, err := os.Lstat(p)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("%s is a symlink", p)
+		}
+	}
+
+	return nil
+}
+
 func untarCreateFile(path string, reader *tar.Reader) error {
 	outFile, err := os.Create(path)
 	if err != nil {
@@ -0,0 +1,131 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type tarEntry struct {
+	name, link string
+	typeflag   byte
+	data       string
+}
+
+func tarBytes(t *testing.T, entries []tarEntry) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Linkname: e.link, Typeflag: e.typeflag, Mode: 0644, Size: int64(len(e.data))}
+		if e.typeflag == tar.TypeDir {
+			hdr.Mode = 0755
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestUntar(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []tarEntry
+		files   map[string]string
+		wantErr bool
+	}{
+		{"Untar1", []tarEntry{
+			{name: "manifest.json", typeflag: tar.TypeReg, data: "[]"},
+			{name: "abc/layer.tar", typeflag: tar.TypeReg, data: "layer"},
+		}, map[string]string{"manifest.json": "[]", "abc/layer.tar": "layer"}, false},
+		{"Untar2", []tarEntry{
+			{name: "dir/", typeflag: tar.TypeDir},
+			{name: "dir/link", link: "../file", typeflag: tar.TypeSymlink},
+			{name: "file", typeflag: tar.TypeReg, data: "data"},
+		}, map[string]string{"dir/link": "data"}, false},
+		{"Untar3", []tarEntry{
+			{name: "../escape", typeflag: tar.TypeReg, data: "x"},
+		}, nil, true},
+		{"Untar4", []tarEntry{
+			{name: "x", link: "/etc", typeflag: tar.TypeSymlink},
+			{name: "x/passwd", typeflag: tar.TypeReg, data: "x"},
+		}, nil, true},
+		{"Untar5", []tarEntry{
+			{name: "x", link: "../../outside", typeflag: tar.TypeSymlink},
+		}, nil, true},
+		{"Untar6", []tarEntry{
+			{name: "x", link: "dir", typeflag: tar.TypeSymlink},
+			{name: "x/passwd", typeflag: tar.TypeReg, data: "x"},
+		}, nil, true},
+		{"Untar7", []tarEntry{
+			{name: "x", link: "file", typeflag: tar.TypeSymlink},
+			{name: "x", typeflag: tar.TypeReg, data: "x"},
+		}, nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmp, err := ioutil.TempDir("", "untar-test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(tmp)
+
+			// dst is a directory below tmp, so escapes land in tmp.
+			dst := filepath.Join(tmp, "a", "dst")
+			if err := os.MkdirAll(dst, os.ModePerm); err != nil {
+				t.Fatal(err)
+			}
+
+			err = Untar(dst, bytes.NewReader(tarBytes(t, tt.entries)))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Untar() error = %v, wantErr %v", err, tt.wantErr)
+			}
+
+			for name, want := range tt.files {
+				b, err := ioutil.ReadFile(filepath.Join(dst, name))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(b) != want {
+					t.Errorf("%s holds %q, want %q", name, b, want)
+				}
+			}
+
+			if _, err := os.Lstat(filepath.Join(tmp, "a", "escape")); err == nil {
+				t.Error("a file was written outside of the destination")
+			}
+		})
+	}
+}
+
+func TestTarContextCancel(t *testing.T) {
+	src := t.TempDir()
+	for _, name := range []string{"a", "b", "c"} {
+		if err := ioutil.WriteFile(filepath.Join(src, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	dst := filepath.Join(t.TempDir(), "image.tar")
+	if err := TarContext(ctx, src, dst); !errors.Is(err, context.Canceled) {
+		t.Errorf("TarContext() error = %v, want %v", err, context.Canceled)
+	}
+	if _, err := os.Stat(dst); !os.IsNotExist(err) {
+		t.Errorf("TarContext() left the partial archive, stat error = %v", err)
+	}
+}
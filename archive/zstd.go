@@ -0,0 +1,57 @@
+/*
+Copyright © 2021 myback.space <git@myback.space>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package archive
+
+import (
+	"io"
+	"os"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+type Zstd struct {
+	dst, src string
+}
+
+func (a *Zstd) Decompress(multiWriter ...io.Writer) (int64, error) {
+	file, err := os.Open(a.src)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	zstdReader, err := zstd.NewReader(file)
+	if err != nil {
+		return 0, err
+	}
+	defer zstdReader.Close()
+
+	unArchFile, err := os.Create(a.dst)
+	if err != nil {
+		return 0, err
+	}
+	defer unArchFile.Close()
+
+	return io.Copy(io.MultiWriter(append([]io.Writer{unArchFile}, multiWriter...)...), zstdReader)
+}
+
+func NewZstd(dst, src string) *Zstd {
+	return &Zstd{
+		dst: dst,
+		src: src,
+	}
+}
@@ -0,0 +1,344 @@
+/*
+Copyright © 2021 myback.space <git@myback.space>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cache
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+)
+
+const (
+	cacheDirName = "docker-pull"
+	blobsDir     = "blobs"
+	locksDir     = "locks"
+
+	lockRetryInterval = 100 * time.Millisecond
+)
+
+// Entry is a blob kept in the cache.
+type Entry struct {
+	Digest digest.Digest
+	Size   int64
+	// LastUsed is when the blob was stored or last taken from the cache.
+	LastUsed time.Time
+}
+
+// Store is a content-addressable blob cache shared by all pulls. Blobs live
+// in blobs/<algorithm>/<hex> and get there by an atomic rename, so a
+// readable blob is always complete. Processes coordinate through per blob
+// lock files, which are never removed.
+type Store struct {
+	root string
+}
+
+// Dir returns the default location of the cache, docker-pull in the user
+// cache directory (~/.cache/docker-pull on Linux).
+func Dir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return filepath.Join(os.TempDir(), cacheDirName)
+	}
+
+	return filepath.Join(dir, cacheDirName)
+}
+
+// Open returns the cache in root creating the directory if needed.
+func Open(root string) (*Store, error) {
+	for _, dir := range []string{filepath.Join(root, blobsDir), filepath.Join(root, locksDir)} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Store{root: root}, nil
+}
+
+func (s *Store) Root() string {
+	return s.root
+}
+
+func (s *Store) path(dgst digest.Digest) string {
+	return filepath.Join(s.root, blobsDir, dgst.Algorithm().String(), dgst.Hex())
+}
+
+func (s *Store) lockPath(dgst digest.Digest) string {
+	return filepath.Join(s.root, locksDir, dgst.Algorithm().String()+"-"+dgst.Hex()+".lock")
+}
+
+// Lock takes the lock of the blob waiting while another process holds it,
+// the returned function releases it. Whoever is about to download a blob
+// takes the lock, so others wait and then find the blob in the cache.
+func (s *Store) Lock(ctx context.Context, dgst digest.Digest) (func(), error) {
+	name := s.lockPath(dgst)
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		err := tryLockFile(f)
+		if err == nil {
+			break
+		}
+		if err != errLocked {
+			f.Close()
+			return nil, err
+		}
+
+		select {
+		case <-ctx.Done():
+			f.Close()
+			return nil, ctx.Err()
+		case <-time.After(lockRetryInterval):
+		}
+	}
+
+	return func() {
+		unlockFile(f)
+		f.Close()
+	}, nil
+}
+
+// Get links the blob to dst, copying it when a hardlink is not possible.
+// It reports false when the cache has no blob of that digest and size. A
+// blob not hashing to its digest any more is removed and reported missing.
+func (s *Store) Get(dgst digest.Digest, size int64, dst string) (bool, error) {
+	src := s.path(dgst)
+	fi, err := os.Stat(src)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	if fi.Size() != size {
+		return false, nil
+	}
+
+	ok, err := verify(src, dgst)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	if !ok {
+		return false, s.Remove(dgst)
+	}
+
+	now := time.Now()
+	if err := os.Chtimes(src, now, now); err != nil {
+		return false, err
+	}
+
+	if err := link(src, dst); err != nil {
+		if os.IsNotExist(err) {
+			// Pruned by another process meanwhile.
+			return false, nil
+		}
+		return false, err
+	}
+
+	return true, nil
+}
+
+// Put stores the verified blob src in the cache.
+func (s *Store) Put(dgst digest.Digest, src string) error {
+	dst := s.path(dgst)
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+
+	if err := link(src, dst); err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	return os.Chtimes(dst, now, now)
+}
+
+// Remove drops the blob from the cache, e.g. when it turned out to be
+// corrupted.
+func (s *Store) Remove(dgst digest.Digest) error {
+	if err := os.Remove(s.path(dgst)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// List returns the cached blobs, the least recently used first.
+func (s *Store) List() ([]Entry, error) {
+	var entries []Entry
+
+	algs, err := ioutil.ReadDir(filepath.Join(s.root, blobsDir))
+	if err != nil {
+		return nil, err
+	}
+	for _, alg := range algs {
+		if !alg.IsDir() {
+			continue
+		}
+
+		files, err := ioutil.ReadDir(filepath.Join(s.root, blobsDir, alg.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, fi := range files {
+			dgst := digest.NewDigestFromEncoded(digest.Algorithm(alg.Name()), fi.Name())
+			if !fi.Mode().IsRegular() || dgst.Validate() != nil {
+				continue
+			}
+
+			entries = append(entries, Entry{Digest: dgst, Size: fi.Size(), LastUsed: fi.ModTime()})
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].LastUsed.Before(entries[j].LastUsed)
+	})
+
+	return entries, nil
+}
+
+// Prune evicts the least recently used blobs until the cache takes at most
+// maxSize bytes and returns the evicted ones. Blobs locked by a running pull
+// are kept.
+func (s *Store) Prune(maxSize int64) ([]Entry, error) {
+	entries, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var total int64
+	for _, e := range entries {
+		total += e.Size
+	}
+
+	var removed []Entry
+	for _, e := range entries {
+		if total <= maxSize {
+			break
+		}
+
+		ok, err := s.evict(e.Digest)
+		if err != nil {
+			return removed, err
+		}
+		if ok {
+			total -= e.Size
+			removed = append(removed, e)
+		}
+	}
+
+	return removed, nil
+}
+
+func (s *Store) evict(dgst digest.Digest) (bool, error) {
+	name := s.lockPath(dgst)
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	if err := tryLockFile(f); err != nil {
+		if err == errLocked {
+			return false, nil
+		}
+		return false, err
+	}
+	defer unlockFile(f)
+
+	// The lock file stays, another process may have opened it already and
+	// would lock a different inode than the next one creating it.
+	if err := s.Remove(dgst); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// verify reports whether the file hashes to dgst.
+func verify(path string, dgst digest.Digest) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	verifier := dgst.Verifier()
+	if _, err := io.Copy(verifier, f); err != nil {
+		return false, err
+	}
+
+	return verifier.Verified(), nil
+}
+
+// link makes dst a hardlink of src or, across file systems, a copy. dst
+// appears atomically in both cases.
+func link(src, dst string) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(dst), filepath.Base(dst)+".tmp-")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+	tmp.Close()
+	os.Remove(tmpName)
+
+	if err := os.Link(src, tmpName); err != nil {
+		if err := copyFile(src, tmpName); err != nil {
+			os.Remove(tmpName)
+			return err
+		}
+	}
+
+	if err := os.Rename(tmpName, dst); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+
+	return nil
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
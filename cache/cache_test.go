@@ -0,0 +1,146 @@
+package cache
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+)
+
+func putBlob(t *testing.T, s *Store, data string, used time.Time) digest.Digest {
+	src := filepath.Join(t.TempDir(), "blob")
+	if err := ioutil.WriteFile(src, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	dgst := digest.FromString(data)
+	if err := s.Put(dgst, src); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(s.path(dgst), used, used); err != nil {
+		t.Fatal(err)
+	}
+
+	return dgst
+}
+
+func TestStore_Get(t *testing.T) {
+	s, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	dgst := putBlob(t, s, "layer", time.Now())
+	dst := filepath.Join(t.TempDir(), "layer.tar.gz")
+
+	// A cached blob changed on disk keeping its size.
+	corrupted := putBlob(t, s, "content", time.Now())
+	if err := ioutil.WriteFile(s.path(corrupted), []byte("CONTENT"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		dgst digest.Digest
+		size int64
+		want bool
+	}{
+		{"Get1", dgst, 5, true},
+		{"Get2", dgst, 6, false},
+		{"Get3", digest.FromString("missing"), 7, false},
+		{"Get4", corrupted, 7, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := s.Get(tt.dgst, tt.size, dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Get() = %v, want %v", got, tt.want)
+			}
+			if got {
+				if b, err := ioutil.ReadFile(dst); err != nil || string(b) != "layer" {
+					t.Errorf("Get() wrote %q, %v", b, err)
+				}
+			}
+			if tt.dgst == corrupted {
+				if _, err := os.Stat(s.path(corrupted)); !os.IsNotExist(err) {
+					t.Errorf("Get() kept the corrupted blob: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestStore_Prune(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name    string
+		maxSize int64
+		want    []string
+	}{
+		{"Prune1", 0, []string{"aaaa", "bbbb", "cccc"}},
+		{"Prune2", 8, []string{"aaaa"}},
+		{"Prune3", 4, []string{"aaaa", "bbbb"}},
+		{"Prune4", 12, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := Open(t.TempDir())
+			if err != nil {
+				t.Fatal(err)
+			}
+			putBlob(t, s, "bbbb", now.Add(-time.Hour))
+			putBlob(t, s, "aaaa", now.Add(-2*time.Hour))
+			putBlob(t, s, "cccc", now)
+
+			removed, err := s.Prune(tt.maxSize)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(removed) != len(tt.want) {
+				t.Fatalf("Prune() removed %d blobs, want %d", len(removed), len(tt.want))
+			}
+			for i, e := range removed {
+				if e.Digest != digest.FromString(tt.want[i]) {
+					t.Errorf("Prune() removed %s, want %s", e.Digest, digest.FromString(tt.want[i]))
+				}
+			}
+		})
+	}
+}
+
+func TestStore_Lock(t *testing.T) {
+	s, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	dgst := putBlob(t, s, "locked", time.Now())
+
+	unlock, err := s.Lock(context.Background(), dgst)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if removed, err := s.Prune(0); err != nil || len(removed) != 0 {
+		t.Errorf("Prune() of a locked blob = %v, %v", removed, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*lockRetryInterval)
+	defer cancel()
+	if _, err := s.Lock(ctx, dgst); err != context.DeadlineExceeded {
+		t.Errorf("Lock() of a locked blob = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	unlock()
+	if removed, err := s.Prune(0); err != nil || len(removed) != 1 {
+		t.Errorf("Prune() after unlock = %v, %v", removed, err)
+	}
+	if _, err := os.Stat(s.lockPath(dgst)); err != nil {
+		t.Errorf("Prune() removed the lock file: %v", err)
+	}
+}
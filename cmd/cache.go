@@ -0,0 +1,105 @@
+/*
+Copyright © 2021 myback.space <git@myback.space>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/docker/go-units"
+	"github.com/myback/go-docker-pull/cache"
+	"github.com/spf13/cobra"
+)
+
+var cacheMaxSize string
+
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Manage the blob cache shared by pulls",
+}
+
+var cacheLsCmd = &cobra.Command{
+	Use:   "ls",
+	Short: "List cached blobs, the least recently used first",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		store, err := cache.Open(cacheDir)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		entries, err := store.List()
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+
+		var total int64
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+		fmt.Fprintln(w, "DIGEST\tSIZE\tLAST USED")
+		for _, e := range entries {
+			total += e.Size
+			fmt.Fprintf(w, "%s\t%s\t%s ago\n", e.Digest, units.HumanSize(float64(e.Size)),
+				units.HumanDuration(time.Since(e.LastUsed)))
+		}
+		w.Flush()
+
+		fmt.Printf("Total: %d blobs, %s\n", len(entries), units.HumanSize(float64(total)))
+	},
+}
+
+var cachePruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Evict the least recently used blobs until the cache fits --max-size",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		maxSize, err := units.FromHumanSize(cacheMaxSize)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		store, err := cache.Open(cacheDir)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		removed, err := store.Prune(maxSize)
+		var reclaimed int64
+		for _, e := range removed {
+			reclaimed += e.Size
+			fmt.Println("Deleted:", e.Digest)
+		}
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+
+		fmt.Println("Total reclaimed space:", units.HumanSize(float64(reclaimed)))
+	},
+}
+
+func init() {
+	cachePruneCmd.Flags().StringVar(&cacheMaxSize, "max-size", "", "Size the cache is shrunk to, e.g. 10GB, 0 evicts everything")
+	_ = cachePruneCmd.MarkFlagRequired("max-size")
+	cacheCmd.AddCommand(cacheLsCmd, cachePruneCmd)
+	rootCmd.AddCommand(cacheCmd)
+}
@@ -0,0 +1,84 @@
+/*
+Copyright © 2021 myback.space <git@myback.space>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	dockerPull "github.com/myback/go-docker-pull"
+	"github.com/spf13/cobra"
+)
+
+var (
+	catalogPrefix   string
+	catalogPageSize int
+	catalogFull     bool
+)
+
+var catalogCmd = &cobra.Command{
+	Use:   "catalog registry",
+	Short: "List the repositories of a registry",
+	Long: `List the repositories of a registry, one per line.
+
+The registry has to grant the registry:catalog:* scope to the user, which
+is usually limited to administrators. With --full-name the names include
+the registry and can be passed to a pull right away:
+
+  docker-pull catalog --full-name --prefix team/ registry.local:5000 | xargs docker-pull`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		rClient, err := registryClient(cmd)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		registry := dockerPull.ParseRegistry(args[0])
+		c, err := rClient.NewClient(registry)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		c.PageSize = catalogPageSize
+
+		repos, err := c.CatalogContext(cmd.Context())
+		if err != nil {
+			fmt.Printf("%s: %s\n", args[0], err)
+			os.Exit(2)
+		}
+
+		for _, repo := range repos {
+			if !strings.HasPrefix(repo, catalogPrefix) {
+				continue
+			}
+
+			if catalogFull {
+				repo = registry.Domain() + "/" + repo
+			}
+			fmt.Println(repo)
+		}
+	},
+}
+
+func init() {
+	catalogCmd.Flags().StringVar(&catalogPrefix, "prefix", "", "Keep the repositories starting with the prefix, e.g. a namespace like team/")
+	catalogCmd.Flags().IntVar(&catalogPageSize, "page-size", 100, "Number of repositories asked for with every request, the registry default when 0")
+	catalogCmd.Flags().BoolVar(&catalogFull, "full-name", false, "Print the repositories with the registry host")
+	rootCmd.AddCommand(catalogCmd)
+}
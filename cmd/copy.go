@@ -0,0 +1,129 @@
+/*
+Copyright © 2021 myback.space <git@myback.space>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/docker/go-units"
+	dockerPull "github.com/myback/go-docker-pull"
+	"github.com/spf13/cobra"
+)
+
+var copySrcCreds, copyDestCreds, copyChunkSize string
+
+var copyCmd = &cobra.Command{
+	Use:   "copy source destination",
+	Short: "Copy an image from one registry to another",
+	Long: `Copy an image from one registry to another.
+
+The blobs are streamed from the source into the destination registry without
+being saved, the ones the destination has already are skipped. Manifests are
+copied unchanged, so the image keeps its digest, a multi-arch image with all
+of its platforms:
+
+  docker-pull copy staging.local/team/app:1.0 registry.local/team/app:1.0
+
+Credentials are taken from the Docker client config of each registry unless
+--src-creds or --dest-creds give them.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		src, err := dockerPull.ParseRequestedImage(args[0])
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		dst, err := dockerPull.ParseRequestedImage(args[1])
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		chunkSize, err := units.RAMInBytes(copyChunkSize)
+		if err != nil {
+			fmt.Printf("invalid chunk size %q: %s\n", copyChunkSize, err)
+			os.Exit(1)
+		}
+
+		// The source and the destination do not share tokens, they may
+		// be different users of one registry.
+		srcRClient, err := registryClient(cmd)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		srcClient, err := srcRClient.NewClient(src)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		dstRClient, err := registryClient(cmd)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		dstRClient.ChunkSize = chunkSize
+
+		dstClient, err := dstRClient.NewClient(dst)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		if err := setCredentials(srcClient, copySrcCreds); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if err := setCredentials(dstClient, copyDestCreds); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		if err := srcClient.CopyToContext(cmd.Context(), dstClient); err != nil {
+			fmt.Printf("%s: %s\n", args[0], err)
+			os.Exit(2)
+		}
+	},
+}
+
+// setCredentials makes the client authenticate with creds, user:password
+// overriding the Docker client config, when they are given.
+func setCredentials(c *dockerPull.Client, creds string) error {
+	if creds == "" {
+		return nil
+	}
+
+	i := strings.IndexByte(creds, ':')
+	if i < 1 {
+		return fmt.Errorf("invalid credentials, expected user:password")
+	}
+	c.SetCredentials(creds[:i], creds[i+1:])
+
+	return nil
+}
+
+func init() {
+	copyCmd.Flags().StringVar(&copySrcCreds, "src-creds", "", "Credentials user:password of the source registry")
+	copyCmd.Flags().StringVar(&copyDestCreds, "dest-creds", "", "Credentials user:password of the destination registry")
+	copyCmd.Flags().StringVar(&copyChunkSize, "chunk-size", "0", "Upload blobs in chunks of the size, e.g. 10MB, with a single request when 0")
+	rootCmd.AddCommand(copyCmd)
+}
@@ -0,0 +1,218 @@
+/*
+Copyright © 2021 myback.space <git@myback.space>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+
+	"github.com/docker/go-units"
+	dockerPull "github.com/myback/go-docker-pull"
+	"github.com/spf13/cobra"
+)
+
+var inspectFormat, inspectPlatform string
+
+var inspectCmd = &cobra.Command{
+	Use:   "inspect image",
+	Short: "Show the manifest and config of an image without downloading its layers",
+	Long: `Show the manifest and config of an image without downloading its layers.
+
+--format takes text, json or a Go template executed on the result, e.g.
+'{{.Digest}}' or '{{range .Images}}{{json .Config.Config.Env}}{{end}}'.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		req, err := dockerPull.ParseRequestedImage(args[0])
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		var tmpl *template.Template
+		if inspectFormat != "text" && inspectFormat != "json" {
+			tmpl, err = template.New("format").Funcs(template.FuncMap{
+				"json": func(v interface{}) (string, error) {
+					b, err := json.Marshal(v)
+					return string(b), err
+				},
+			}).Parse(inspectFormat)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		}
+
+		rClient, err := inspectClient(cmd)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		info, err := rClient.InspectContext(cmd.Context(), req)
+		if err != nil {
+			fmt.Printf("%s: %s\n", args[0], err)
+			os.Exit(2)
+		}
+
+		switch {
+		case tmpl != nil:
+			err = tmpl.Execute(os.Stdout, info)
+			fmt.Println()
+		case inspectFormat == "json":
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "    ")
+			err = enc.Encode(info)
+		default:
+			err = printInspect(os.Stdout, info)
+		}
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+	},
+}
+
+func inspectClient(cmd *cobra.Command) (*dockerPull.RegistryClient, error) {
+	rClient, err := registryClient(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	if inspectPlatform == "all" {
+		rClient.AllPlatforms = true
+		return rClient, nil
+	}
+
+	for _, p := range strings.Split(inspectPlatform, ",") {
+		pl, err := dockerPull.ParsePlatform(p)
+		if err != nil {
+			return nil, err
+		}
+		rClient.Platforms = append(rClient.Platforms, pl)
+	}
+
+	return rClient, nil
+}
+
+// printInspect writes info as text, an image after another.
+func printInspect(out io.Writer, info *dockerPull.ImageInspect) error {
+	w := tabwriter.NewWriter(out, 0, 0, 1, ' ', 0)
+	fmt.Fprintf(w, "Name:\t%s\n", info.Name)
+	fmt.Fprintf(w, "Digest:\t%s\n", info.Digest)
+	fmt.Fprintf(w, "MediaType:\t%s\n", info.MediaType)
+	if len(info.Platforms) > 0 {
+		platforms := make([]string, 0, len(info.Platforms))
+		for _, p := range info.Platforms {
+			platforms = append(platforms, p.String())
+		}
+		fmt.Fprintf(w, "Platforms:\t%s\n", strings.Join(platforms, ", "))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	for _, img := range info.Images {
+		fmt.Fprintln(out)
+
+		w := tabwriter.NewWriter(out, 0, 0, 1, ' ', 0)
+		fmt.Fprintf(w, "Platform:\t%s\n", img.Platform)
+		fmt.Fprintf(w, "Digest:\t%s\n", img.Digest)
+		fmt.Fprintf(w, "MediaType:\t%s\n", img.MediaType)
+		fmt.Fprintf(w, "Config:\t%s\n", img.ConfigDigest)
+		fmt.Fprintf(w, "Created:\t%s\n", img.Config.Created.UTC().Format("2006-01-02T15:04:05Z"))
+		if c := img.Config.Config; c != nil {
+			if len(c.Entrypoint) > 0 {
+				fmt.Fprintf(w, "Entrypoint:\t%q\n", []string(c.Entrypoint))
+			}
+			if len(c.Cmd) > 0 {
+				fmt.Fprintf(w, "Cmd:\t%q\n", []string(c.Cmd))
+			}
+			if c.WorkingDir != "" {
+				fmt.Fprintf(w, "WorkingDir:\t%s\n", c.WorkingDir)
+			}
+			if c.User != "" {
+				fmt.Fprintf(w, "User:\t%s\n", c.User)
+			}
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+
+		if c := img.Config.Config; c != nil {
+			if len(c.Env) > 0 {
+				fmt.Fprintln(out, "Env:")
+				for _, e := range c.Env {
+					fmt.Fprintln(out, "  "+e)
+				}
+			}
+
+			if len(c.Labels) > 0 {
+				keys := make([]string, 0, len(c.Labels))
+				for k := range c.Labels {
+					keys = append(keys, k)
+				}
+				sort.Strings(keys)
+
+				fmt.Fprintln(out, "Labels:")
+				for _, k := range keys {
+					fmt.Fprintf(out, "  %s=%s\n", k, c.Labels[k])
+				}
+			}
+		}
+
+		var total int64
+		fmt.Fprintln(out, "Layers:")
+		w = tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+		for _, l := range img.Layers {
+			total += l.Size
+			fmt.Fprintf(w, "  %s\t%s\t%s\n", l.Digest, units.HumanSize(float64(l.Size)), l.MediaType)
+		}
+		fmt.Fprintf(w, "  Total:\t%s\n", units.HumanSize(float64(total)))
+		if err := w.Flush(); err != nil {
+			return err
+		}
+
+		if len(img.Config.History) > 0 {
+			fmt.Fprintln(out, "History:")
+			w = tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+			for _, h := range img.Config.History {
+				createdBy := h.CreatedBy
+				if h.EmptyLayer {
+					createdBy += " (empty layer)"
+				}
+				fmt.Fprintf(w, "  %s\t%s\n", h.Created.UTC().Format("2006-01-02T15:04:05Z"), createdBy)
+			}
+			if err := w.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func init() {
+	inspectCmd.Flags().StringVarP(&inspectFormat, "format", "f", "text", "Output format: text, json or a Go template")
+	inspectCmd.Flags().StringVar(&inspectPlatform, "platform", "linux/amd64", "Platforms os[(os.version)]/arch[/variant] to inspect in a multi-arch image, all or a list like linux/amd64,linux/arm64/v8")
+	rootCmd.AddCommand(inspectCmd)
+}
@@ -0,0 +1,171 @@
+/*
+Copyright © 2021 myback.space <git@myback.space>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/moby/term"
+	dockerPull "github.com/myback/go-docker-pull"
+	"github.com/myback/go-docker-pull/config"
+	"github.com/spf13/cobra"
+)
+
+var (
+	loginUser, loginPassword string
+	loginPasswordStdin       bool
+)
+
+var loginCmd = &cobra.Command{
+	Use:   "login [registry]",
+	Short: "Log in to a registry, Docker Hub if no registry is given",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		serverAddress := config.IndexServer
+		if len(args) > 0 {
+			serverAddress = args[0]
+		}
+
+		if loginPasswordStdin {
+			if loginUser == "" || loginPassword != "" {
+				fmt.Println("--password-stdin requires --username and excludes --password")
+				os.Exit(1)
+			}
+
+			p, err := readPasswordStdin()
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			loginPassword = p
+		}
+
+		if err := promptCredentials(); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		cfg, err := config.Load(configDir)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		settings, err := config.LoadSettings(settingsFile)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		rClient := dockerPull.RegistryClient{
+			Login:    loginUser,
+			Password: loginPassword,
+
+			CACert:                caCert,
+			Cert:                  clientCert,
+			Key:                   clientKey,
+			InsecureSkipTLSVerify: insecureSkipTLSVerify,
+			InsecureRegistries:    insecureRegistries(cmd, settings),
+			CertsDirs:             certsDirs(),
+			Proxy:                 proxy,
+			NoProxy:               noProxy,
+			Proxies:               settings.Proxies,
+		}
+		if err := rClient.AuthenticateContext(cmd.Context(), serverAddress); err != nil {
+			fmt.Printf("%s: %s\n", serverAddress, err)
+			os.Exit(2)
+		}
+
+		if err := cfg.Store(serverAddress, config.AuthConfig{
+			Username: loginUser,
+			Password: loginPassword,
+		}); err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+
+		if err := cfg.Save(); err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+
+		fmt.Println("Login Succeeded")
+	},
+}
+
+func readPasswordStdin() (string, error) {
+	b, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+// promptCredentials asks for the username and password which are not given
+// by flags, the password is read with the terminal echo turned off.
+func promptCredentials() error {
+	reader := bufio.NewReader(os.Stdin)
+
+	if loginUser == "" {
+		fmt.Print("Username: ")
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return err
+		}
+		loginUser = strings.TrimSpace(line)
+	}
+
+	if loginPassword == "" {
+		fd, isTerminal := term.GetFdInfo(os.Stdin)
+		if isTerminal {
+			state, err := term.SaveState(fd)
+			if err != nil {
+				return err
+			}
+			if err := term.DisableEcho(fd, state); err != nil {
+				return err
+			}
+			defer term.RestoreTerminal(fd, state)
+		}
+
+		fmt.Print("Password: ")
+		line, err := reader.ReadString('\n')
+		fmt.Println()
+		if err != nil {
+			return err
+		}
+		loginPassword = strings.TrimRight(line, "\r\n")
+	}
+
+	if loginUser == "" || loginPassword == "" {
+		return fmt.Errorf("username and password are required")
+	}
+
+	return nil
+}
+
+func init() {
+	loginCmd.Flags().StringVarP(&loginUser, "username", "u", "", "Registry user")
+	loginCmd.Flags().StringVarP(&loginPassword, "password", "p", "", "Registry password")
+	loginCmd.Flags().BoolVar(&loginPasswordStdin, "password-stdin", false, "Take the registry password from stdin")
+	rootCmd.AddCommand(loginCmd)
+}
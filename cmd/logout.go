@@ -0,0 +1,59 @@
+/*
+Copyright © 2021 myback.space <git@myback.space>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/myback/go-docker-pull/config"
+	"github.com/spf13/cobra"
+)
+
+var logoutCmd = &cobra.Command{
+	Use:   "logout [registry]",
+	Short: "Log out from a registry, Docker Hub if no registry is given",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		serverAddress := config.IndexServer
+		if len(args) > 0 {
+			serverAddress = args[0]
+		}
+
+		cfg, err := config.Load(configDir)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		if !cfg.Erase(serverAddress) {
+			fmt.Printf("Not logged in to %s\n", serverAddress)
+			return
+		}
+
+		if err := cfg.Save(); err != nil {
+			fmt.Println(err)
+			os.Exit(2)
+		}
+
+		fmt.Printf("Removing login credentials for %s\n", serverAddress)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(logoutCmd)
+}
@@ -0,0 +1,87 @@
+/*
+Copyright © 2021 myback.space <git@myback.space>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/docker/distribution/reference"
+	"github.com/docker/go-units"
+	dockerPull "github.com/myback/go-docker-pull"
+	"github.com/spf13/cobra"
+)
+
+var (
+	pushMountFrom []string
+	pushChunkSize string
+)
+
+var pushCmd = &cobra.Command{
+	Use:   "push archive image",
+	Short: "Push an image saved by a pull to a registry",
+	Long: `Push an image saved by a pull to a registry.
+
+The archive is a docker-save or OCI layout tar file, or the directory of an
+extracted one like the temp folder kept by --save-cache. The layers of a
+docker-save archive are compressed with gzip before the upload, an OCI
+layout is pushed unchanged. Blobs the registry has already are skipped,
+--mount-from names repositories of the same registry to link them from:
+
+  docker-pull push --mount-from library/alpine app_1.0.tar registry.local:5000/team/app:1.0`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		req, err := dockerPull.ParseRequestedImage(args[1])
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		chunkSize, err := units.RAMInBytes(pushChunkSize)
+		if err != nil {
+			fmt.Printf("invalid chunk size %q: %s\n", pushChunkSize, err)
+			os.Exit(1)
+		}
+
+		rClient, err := registryClient(cmd)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		rClient.ChunkSize = chunkSize
+
+		for _, from := range pushMountFrom {
+			named, err := reference.ParseNormalizedNamed(from)
+			if err != nil {
+				fmt.Printf("invalid repository %q: %s\n", from, err)
+				os.Exit(1)
+			}
+			rClient.MountFrom = append(rClient.MountFrom, reference.Path(named))
+		}
+
+		if err := rClient.PushContext(cmd.Context(), args[0], req); err != nil {
+			fmt.Printf("%s: %s\n", args[1], err)
+			os.Exit(2)
+		}
+	},
+}
+
+func init() {
+	pushCmd.Flags().StringArrayVar(&pushMountFrom, "mount-from", nil, "Repository of the destination registry blobs are mounted from instead of uploaded, may be repeated")
+	pushCmd.Flags().StringVar(&pushChunkSize, "chunk-size", "0", "Upload blobs in chunks of the size, e.g. 10MB, with a single request when 0")
+	rootCmd.AddCommand(pushCmd)
+}
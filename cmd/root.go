@@ -17,23 +17,45 @@ limitations under the License.
 package cmd
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/docker/go-units"
 	dockerPull "github.com/myback/go-docker-pull"
 	"github.com/myback/go-docker-pull/archive"
+	"github.com/myback/go-docker-pull/cache"
+	"github.com/myback/go-docker-pull/config"
 	"github.com/spf13/cobra"
 )
 
 var (
 	//verbose                      int
-	saveCache, onlyDownload                     bool
-	arch, osType, registryProxy, user, password string
+	saveCache, onlyDownload, platformFallback  bool
+	arch, osType, user, password, settingsFile string
+	registryMirrors, insecureRegistryFlags     []string
+	format, configDir, platform, cacheDir      string
+	noCache, insecureSkipTLSVerify             bool
+	caCert, clientCert, clientKey              string
+	proxy, noProxy, pullTag                    string
+	cacheMaxSizeFlag                           string
+	passwordStdin                              bool
+	maxConcurrentDownloads, retryAttempts      int
+	retryBackoff                               time.Duration
+	retryJitter                                float64
+	platforms                                  []dockerPull.Platform
 )
 
 // rootCmd represents the base command when called without any subcommands
 var rootCmd = &cobra.Command{
-	Use: "docker-pull image [image ...]",
+	Use:  "docker-pull image [image ...]",
+	Args: cobra.ArbitraryArgs,
 	// Uncomment the following line if your bare application
 	// has an action associated with it:
 	PreRun: func(cmd *cobra.Command, args []string) {
@@ -41,46 +63,241 @@ var rootCmd = &cobra.Command{
 			_ = cmd.Usage()
 			os.Exit(1)
 		}
+
+		if passwordStdin {
+			if user == "" || password != "" {
+				fmt.Println("--password-stdin requires --user and excludes --password")
+				os.Exit(1)
+			}
+
+			p, err := readPasswordStdin()
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			password = p
+		}
+
+		if platform != "" && platform != "all" {
+			for _, p := range strings.Split(platform, ",") {
+				pl, err := dockerPull.ParsePlatform(p)
+				if err != nil {
+					fmt.Println(err)
+					os.Exit(1)
+				}
+				platforms = append(platforms, pl)
+			}
+		}
+
+		if format != dockerPull.FormatDocker && format != dockerPull.FormatOCI {
+			fmt.Printf("unknown format %q, must be %s or %s\n", format, dockerPull.FormatDocker, dockerPull.FormatOCI)
+			os.Exit(1)
+		}
 	},
 	Run: func(cmd *cobra.Command, args []string) {
+		cfg, err := config.Load(configDir)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		settings, err := config.LoadSettings(settingsFile)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		mirrors := registryMirrors
+		if !cmd.Flags().Changed("registry-mirror") {
+			mirrors = settings.RegistryMirrors
+		}
+
+		if retryAttempts < 1 {
+			fmt.Printf("invalid retry attempts %d: at least one attempt is required\n", retryAttempts)
+			os.Exit(1)
+		}
+
+		maxCacheSize, err := units.FromHumanSize(cacheMaxSizeFlag)
+		if err != nil {
+			fmt.Printf("invalid cache size %q: %s\n", cacheMaxSizeFlag, err)
+			os.Exit(1)
+		}
+
+		var store *cache.Store
+		if !noCache {
+			if store, err = cache.Open(cacheDir); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		}
+
 		rClient := dockerPull.RegistryClient{
 			Arch:     arch,
 			OS:       osType,
 			Login:    user,
 			Password: password,
+
+			MaxConcurrentDownloads: maxConcurrentDownloads,
+			Format:                 format,
+			Config:                 cfg,
+			Retry: dockerPull.RetryPolicy{
+				Attempts:   retryAttempts,
+				Backoff:    retryBackoff,
+				MaxBackoff: dockerPull.DefaultRetryPolicy.MaxBackoff,
+				Jitter:     retryJitter,
+			},
+			Mirrors: mirrors,
+
+			CACert:                caCert,
+			Cert:                  clientCert,
+			Key:                   clientKey,
+			InsecureSkipTLSVerify: insecureSkipTLSVerify,
+			InsecureRegistries:    insecureRegistries(cmd, settings),
+			CertsDirs:             certsDirs(),
+			Proxy:                 proxy,
+			NoProxy:               noProxy,
+			Proxies:               settings.Proxies,
+
+			Cache:     store,
+			SaveCache: saveCache,
 		}
 
+		rClient.AllPlatforms = platform == "all"
+		rClient.Platforms = platforms
+		rClient.AllowPlatformFallback = platformFallback
+
+		ctx := cmd.Context()
 		for _, img := range args {
-			req := dockerPull.ParseRequestedImage(img)
+			req, err := dockerPull.ParseRequestedImage(img)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			if pullTag != "" {
+				if err := req.SetTag(pullTag); err != nil {
+					fmt.Printf("%s: %s\n", img, err)
+					os.Exit(1)
+				}
+			}
+
+			if err := rClient.PullContext(ctx, req); err != nil {
+				var notFound *dockerPull.ErrPlatformNotFound
+				if errors.As(err, &notFound) {
+					fmt.Printf("%s: no matching manifest for %s, available platforms:\n", img, notFound.Platform)
+					for _, p := range notFound.Available {
+						fmt.Println("  " + p.String())
+					}
+					if !platformFallback {
+						fmt.Println("choose one with --platform or use --allow-platform-fallback")
+					}
+					os.Exit(2)
+				}
 
-			if err := rClient.Pull(req); err != nil {
 				fmt.Printf("%s: %s\n", img, err)
 				os.Exit(2)
 			}
 
 			if onlyDownload {
+				pruneCache(store, maxCacheSize)
 				os.Exit(0)
 			}
 
-			if err := archive.Tar(req.TempDir(), req.OutputImageName()); err != nil {
-				fmt.Println(err)
-				os.Exit(2)
-			}
-
-			if !saveCache {
-				if err := os.RemoveAll(req.TempDir()); err != nil {
+			for _, out := range req.Outputs() {
+				if err := archive.TarContext(ctx, out.TempDir(), out.OutputImageName()); err != nil {
+					if ctx.Err() != nil && !saveCache {
+						for _, o := range req.Outputs() {
+							os.RemoveAll(o.TempDir())
+						}
+					}
 					fmt.Println(err)
 					os.Exit(2)
 				}
+
+				if !saveCache {
+					if err := os.RemoveAll(out.TempDir()); err != nil {
+						fmt.Println(err)
+						os.Exit(2)
+					}
+				}
 			}
 		}
+
+		pruneCache(store, maxCacheSize)
 	},
 }
 
+// pruneCache shrinks the cache to maxSize after a pull, a failure only
+// leaves it larger.
+func pruneCache(store *cache.Store, maxSize int64) {
+	if store == nil {
+		return
+	}
+
+	if _, err := store.Prune(maxSize); err != nil {
+		fmt.Println("prune cache:", err)
+	}
+}
+
+// certsDirs are the certs.d directories of per registry certificates: the
+// one of the Docker daemon and the one next to the Docker client config.
+func certsDirs() []string {
+	return []string{dockerPull.SystemCertsDir, filepath.Join(configDir, "certs.d")}
+}
+
+// registryClient returns a RegistryClient of the registry commands taking
+// credentials from the Docker client config and connection settings from
+// the persistent flags and the settings file.
+func registryClient(cmd *cobra.Command) (*dockerPull.RegistryClient, error) {
+	cfg, err := config.Load(configDir)
+	if err != nil {
+		return nil, err
+	}
+
+	settings, err := config.LoadSettings(settingsFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &dockerPull.RegistryClient{
+		Config:  cfg,
+		Mirrors: settings.RegistryMirrors,
+
+		CACert:                caCert,
+		Cert:                  clientCert,
+		Key:                   clientKey,
+		InsecureSkipTLSVerify: insecureSkipTLSVerify,
+		InsecureRegistries:    insecureRegistries(cmd, settings),
+		CertsDirs:             certsDirs(),
+		Proxy:                 proxy,
+		NoProxy:               noProxy,
+		Proxies:               settings.Proxies,
+	}, nil
+}
+
+// insecureRegistries are the --insecure-registry hosts, insecure-registries
+// of the settings file when the flag is not given.
+func insecureRegistries(cmd *cobra.Command, settings *config.Settings) []string {
+	if cmd.Flags().Changed("insecure-registry") {
+		return insecureRegistryFlags
+	}
+
+	return settings.InsecureRegistries
+}
+
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
+// SIGINT and SIGTERM cancel the running command, a second signal kills the
+// process right away.
 func Execute() {
-	if err := rootCmd.Execute(); err != nil {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	if err := rootCmd.ExecuteContext(ctx); err != nil {
 		fmt.Println(err)
 		os.Exit(1)
 	}
@@ -92,6 +309,28 @@ func init() {
 	//rootCmd.Flags().CountVarP(&verbose, "verbose", "v", "")
 	rootCmd.Flags().StringVarP(&arch, "arch", "a", "amd64", "CPU architecture platform image")
 	rootCmd.Flags().StringVarP(&osType, "os", "o", "linux", "OS platform image")
+	rootCmd.Flags().BoolVar(&platformFallback, "allow-platform-fallback", false, "Take the nearest compatible platform when the requested one is missing: another variant or Windows build, 386 on amd64, arm on arm64")
+	rootCmd.Flags().StringVar(&platform, "platform", "", "Platforms os[(os.version)]/arch[/variant] to pull from a multi-arch image, all or a list like linux/amd64,linux/arm64/v8. Overrides --arch and --os")
 	rootCmd.Flags().StringVarP(&user, "user", "u", "", "Registry user")
 	rootCmd.Flags().StringVarP(&password, "password", "p", "", "Registry password")
+	rootCmd.Flags().BoolVar(&passwordStdin, "password-stdin", false, "Take the registry password from stdin")
+	rootCmd.Flags().IntVar(&retryAttempts, "retry-attempts", dockerPull.DefaultRetryPolicy.Attempts, "Number of attempts of a failed request")
+	rootCmd.Flags().DurationVar(&retryBackoff, "retry-backoff", dockerPull.DefaultRetryPolicy.Backoff, "Delay before the first retry, doubled every next one")
+	rootCmd.Flags().Float64Var(&retryJitter, "retry-jitter", dockerPull.DefaultRetryPolicy.Jitter, "Fraction of the retry delay randomized")
+	rootCmd.PersistentFlags().StringVar(&configDir, "config", config.Dir(), "Location of the Docker client config files")
+	rootCmd.PersistentFlags().StringVar(&settingsFile, "settings", config.SettingsFile(), "Location of the docker-pull settings file")
+	rootCmd.Flags().StringArrayVar(&registryMirrors, "registry-mirror", nil, "Mirror asked for Docker Hub images before Docker Hub, may be repeated. Defaults to registry-mirrors of the settings file")
+	rootCmd.PersistentFlags().StringVar(&caCert, "ca-cert", "", "PEM bundle of CA certificates trusted in addition to the system ones")
+	rootCmd.PersistentFlags().StringVar(&clientCert, "cert", "", "Client certificate for registries requiring mutual TLS")
+	rootCmd.PersistentFlags().StringVar(&clientKey, "key", "", "Key of the client certificate")
+	rootCmd.PersistentFlags().StringArrayVar(&insecureRegistryFlags, "insecure-registry", nil, "Registry host[:port] or CIDR reached without certificate verification or over plain HTTP, may be repeated. Defaults to insecure-registries of the settings file")
+	rootCmd.PersistentFlags().BoolVar(&insecureSkipTLSVerify, "insecure-skip-tls-verify", false, "Do not verify the registry certificates")
+	rootCmd.PersistentFlags().StringVar(&proxy, "proxy", "", "Proxy of the registries, http://, https:// or socks5:// with optional user:password@. Defaults to HTTP_PROXY and HTTPS_PROXY")
+	rootCmd.PersistentFlags().StringVar(&noProxy, "no-proxy", "", "Comma separated hosts, domains and CIDRs reached without the proxy. Defaults to NO_PROXY")
+	rootCmd.PersistentFlags().StringVar(&cacheDir, "cache-dir", cache.Dir(), "Location of the blob cache shared by pulls")
+	rootCmd.Flags().BoolVar(&noCache, "no-cache", false, "Do not use the blob cache")
+	rootCmd.Flags().StringVar(&cacheMaxSizeFlag, "cache-max-size", "10GB", "Size the blob cache is shrunk to after a pull, the least recently used blobs are evicted")
+	rootCmd.Flags().StringVarP(&pullTag, "tag", "t", "", "Tag of an image pulled by digest in the archive, it is saved untagged otherwise")
+	rootCmd.Flags().StringVarP(&format, "format", "f", dockerPull.FormatDocker, "Output format: docker (docker save) or oci (OCI image layout)")
+	rootCmd.Flags().IntVar(&maxConcurrentDownloads, "max-concurrent-downloads", 3, "Maximum number of layers downloaded in parallel")
 }
@@ -0,0 +1,135 @@
+/*
+Copyright © 2021 myback.space <git@myback.space>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+
+	dockerPull "github.com/myback/go-docker-pull"
+	"github.com/spf13/cobra"
+)
+
+var (
+	tagsRegex, tagsSemver string
+	tagsPageSize          int
+)
+
+var tagsCmd = &cobra.Command{
+	Use:   "tags repository",
+	Short: "List the tags of a repository",
+	Long: `List the tags of a repository.
+
+--semver keeps the tags which are versions in the range, e.g. '>=1.2 <2',
+'^1.2', '~1.2.3' or '1.x || 2.x', and sorts them by version.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		req, err := dockerPull.ParseRequestedImage(args[0])
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if !req.IsNameOnly() {
+			fmt.Printf("%s: tags takes a repository, remove the tag or digest\n", args[0])
+			os.Exit(1)
+		}
+
+		var re *regexp.Regexp
+		if tagsRegex != "" {
+			if re, err = regexp.Compile(tagsRegex); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		}
+
+		var versionRange dockerPull.VersionRange
+		if tagsSemver != "" {
+			if versionRange, err = dockerPull.ParseVersionRange(tagsSemver); err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+		}
+
+		rClient, err := registryClient(cmd)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		c, err := rClient.NewClient(req)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		c.PageSize = tagsPageSize
+
+		tags, err := c.TagsContext(cmd.Context())
+		if err != nil {
+			fmt.Printf("%s: %s\n", args[0], err)
+			os.Exit(2)
+		}
+
+		if re != nil {
+			tags = filterTags(tags, func(tag string) bool {
+				return re.MatchString(tag)
+			})
+		}
+
+		if versionRange != nil {
+			versions := map[string]dockerPull.Version{}
+			tags = filterTags(tags, func(tag string) bool {
+				v, err := dockerPull.ParseVersion(tag)
+				if err != nil || !versionRange.Contains(v) {
+					return false
+				}
+				versions[tag] = v
+				return true
+			})
+
+			sort.SliceStable(tags, func(i, j int) bool {
+				if c := versions[tags[i]].Compare(versions[tags[j]]); c != 0 {
+					return c < 0
+				}
+				return tags[i] < tags[j]
+			})
+		}
+
+		for _, tag := range tags {
+			fmt.Println(tag)
+		}
+	},
+}
+
+func filterTags(tags []string, keep func(string) bool) []string {
+	var kept []string
+	for _, tag := range tags {
+		if keep(tag) {
+			kept = append(kept, tag)
+		}
+	}
+
+	return kept
+}
+
+func init() {
+	tagsCmd.Flags().StringVar(&tagsRegex, "regex", "", "Keep the tags matching the regular expression")
+	tagsCmd.Flags().StringVar(&tagsSemver, "semver", "", "Keep the tags which are semantic versions in the range and sort them by version")
+	tagsCmd.Flags().IntVar(&tagsPageSize, "page-size", 100, "Number of tags asked for with every request, the registry default when 0")
+	rootCmd.AddCommand(tagsCmd)
+}
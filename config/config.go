@@ -0,0 +1,302 @@
+/*
+Copyright © 2021 myback.space <git@myback.space>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	ConfigFileName = "config.json"
+	configFileDir  = ".docker"
+
+	// IndexServer is the key Docker Hub credentials are stored under.
+	IndexServer = "https://index.docker.io/v1/"
+)
+
+// AuthConfig holds the credentials of one registry. IdentityToken, when set,
+// is an OAuth2 refresh token used instead of the password.
+type AuthConfig struct {
+	Username      string `json:"username,omitempty"`
+	Password      string `json:"password,omitempty"`
+	Auth          string `json:"auth,omitempty"`
+	IdentityToken string `json:"identitytoken,omitempty"`
+}
+
+// ConfigFile is the part of the Docker client config.json related to
+// registry credentials.
+type ConfigFile struct {
+	AuthConfigs       map[string]AuthConfig `json:"auths"`
+	CredentialsStore  string                `json:"credsStore,omitempty"`
+	CredentialHelpers map[string]string     `json:"credHelpers,omitempty"`
+	Filename          string                `json:"-"`
+
+	// raw keeps every key of the file, so Save does not drop the settings
+	// docker-pull knows nothing about.
+	raw map[string]json.RawMessage
+}
+
+// Dir returns the directory of the Docker client config: $DOCKER_CONFIG or
+// ~/.docker.
+func Dir() string {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return dir
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return configFileDir
+	}
+
+	return filepath.Join(home, configFileDir)
+}
+
+// Load reads config.json from dir. A missing file is not an error, an empty
+// config is returned instead.
+func Load(dir string) (*ConfigFile, error) {
+	cf := &ConfigFile{
+		AuthConfigs: map[string]AuthConfig{},
+		Filename:    filepath.Join(dir, ConfigFileName),
+	}
+
+	f, err := os.Open(cf.Filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return cf, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(b, &cf.raw); err != nil {
+		return nil, fmt.Errorf("%s: %s", cf.Filename, err)
+	}
+
+	if err := json.Unmarshal(b, cf); err != nil {
+		return nil, fmt.Errorf("%s: %s", cf.Filename, err)
+	}
+
+	if cf.AuthConfigs == nil {
+		cf.AuthConfigs = map[string]AuthConfig{}
+	}
+
+	return cf, nil
+}
+
+// GetAuthConfig returns the credentials of the registry. A credential helper
+// configured for the host in credHelpers or globally in credsStore is asked
+// first, then the auths section is looked up. Empty credentials are returned
+// when nothing is found.
+func (cf *ConfigFile) GetAuthConfig(serverAddress string) (AuthConfig, error) {
+	hostname := ConvertToHostname(serverAddress)
+
+	if helper := cf.helperFor(hostname); helper != "" {
+		auth, err := helperGet(helper, serverAddress)
+		if err != nil && err != errCredentialsNotFound {
+			return AuthConfig{}, err
+		}
+		if err == nil {
+			return auth, nil
+		}
+	}
+
+	for key, auth := range cf.AuthConfigs {
+		if ConvertToHostname(key) != hostname {
+			continue
+		}
+
+		if auth.Auth != "" {
+			if err := decodeAuth(&auth); err != nil {
+				return AuthConfig{}, fmt.Errorf("%s: %s: %s", cf.Filename, key, err)
+			}
+		}
+
+		return auth, nil
+	}
+
+	return AuthConfig{}, nil
+}
+
+// Store saves the credentials of the registry with its credential helper if
+// one is configured, otherwise base64 encoded in the auths section. Docker
+// Hub aliases like docker.io are stored as IndexServer. The config file
+// itself is written by Save.
+func (cf *ConfigFile) Store(serverAddress string, auth AuthConfig) error {
+	serverAddress = normalizeServerAddress(serverAddress)
+	cf.Erase(serverAddress)
+
+	if helper := cf.helperFor(ConvertToHostname(serverAddress)); helper != "" {
+		if err := helperStore(helper, serverAddress, auth); err != nil {
+			return err
+		}
+
+		// Like docker, keep an empty entry to know the registry is logged in.
+		cf.AuthConfigs[serverAddress] = AuthConfig{}
+		return nil
+	}
+
+	cf.AuthConfigs[serverAddress] = AuthConfig{
+		Auth:          base64.StdEncoding.EncodeToString([]byte(auth.Username + ":" + auth.Password)),
+		IdentityToken: auth.IdentityToken,
+	}
+
+	return nil
+}
+
+// Erase removes the credentials of the registry from the auths section and
+// its credential helper. It reports whether there was anything to remove.
+func (cf *ConfigFile) Erase(serverAddress string) bool {
+	serverAddress = normalizeServerAddress(serverAddress)
+	hostname := ConvertToHostname(serverAddress)
+
+	var found bool
+	for key := range cf.AuthConfigs {
+		if ConvertToHostname(key) == hostname {
+			delete(cf.AuthConfigs, key)
+			found = true
+		}
+	}
+
+	if helper := cf.helperFor(hostname); helper != "" {
+		if err := helperErase(helper, serverAddress); err == nil {
+			found = true
+		}
+	}
+
+	return found
+}
+
+// Save writes the config file atomically, readable by the owner only.
+func (cf *ConfigFile) Save() error {
+	if cf.raw == nil {
+		cf.raw = map[string]json.RawMessage{}
+	}
+
+	fields, err := json.Marshal(cf)
+	if err != nil {
+		return err
+	}
+
+	known := map[string]json.RawMessage{}
+	if err := json.Unmarshal(fields, &known); err != nil {
+		return err
+	}
+
+	for _, key := range []string{"auths", "credsStore", "credHelpers"} {
+		if v, ok := known[key]; ok {
+			cf.raw[key] = v
+		} else {
+			delete(cf.raw, key)
+		}
+	}
+
+	b, err := json.MarshalIndent(cf.raw, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(cf.Filename)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(dir, filepath.Base(cf.Filename))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(append(b, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Chmod(tmp.Name(), 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), cf.Filename)
+}
+
+func (cf *ConfigFile) helperFor(hostname string) string {
+	for key, helper := range cf.CredentialHelpers {
+		if ConvertToHostname(key) == hostname {
+			return helper
+		}
+	}
+
+	return cf.CredentialsStore
+}
+
+// ConvertToHostname strips the scheme and path from a registry address, so
+// https://index.docker.io/v1/ and index.docker.io match. All Docker Hub
+// aliases are converted into index.docker.io.
+func ConvertToHostname(serverAddress string) string {
+	hostname := serverAddress
+	if idx := strings.Index(hostname, "://"); idx > -1 {
+		hostname = hostname[idx+3:]
+	}
+	hostname = strings.SplitN(hostname, "/", 2)[0]
+
+	switch hostname {
+	case "docker.io", "registry-1.docker.io", "registry.hub.docker.com":
+		return "index.docker.io"
+	}
+
+	return hostname
+}
+
+// normalizeServerAddress turns every Docker Hub alias into IndexServer,
+// the key docker login stores its credentials under.
+func normalizeServerAddress(serverAddress string) string {
+	if ConvertToHostname(serverAddress) == "index.docker.io" {
+		return IndexServer
+	}
+
+	return serverAddress
+}
+
+func decodeAuth(auth *AuthConfig) error {
+	b, err := base64.StdEncoding.DecodeString(auth.Auth)
+	if err != nil {
+		return err
+	}
+
+	userPass := strings.SplitN(string(b), ":", 2)
+	if len(userPass) != 2 {
+		return fmt.Errorf("invalid auth configuration")
+	}
+
+	auth.Username = userPass[0]
+	auth.Password = strings.Trim(userPass[1], "\x00")
+
+	return nil
+}
@@ -0,0 +1,180 @@
+package config
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+const fakeHelper = `#!/bin/sh
+read server
+case "$server" in
+  helper.registry) echo '{"ServerURL":"helper.registry","Username":"helper-user","Secret":"helper-pass"}' ;;
+  https://index.docker.io/v1/) echo '{"ServerURL":"https://index.docker.io/v1/","Username":"<token>","Secret":"identity"}' ;;
+  broken.registry) echo 'helper exploded' >&2; exit 1 ;;
+  *) echo 'credentials not found in native keychain'; exit 1 ;;
+esac
+`
+
+const fakeConfig = `{
+	"auths": {
+		"https://file.registry/v1/": {"auth": "ZmlsZS11c2VyOmZpbGUtcGFzcw=="},
+		"token.registry": {"identitytoken": "refresh"}
+	},
+	"credHelpers": {
+		"helper.registry": "fake",
+		"broken.registry": "fake",
+		"docker.io": "fake"
+	}
+}`
+
+func TestConfigFile_GetAuthConfig(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake credential helper is a shell script")
+	}
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, helperPrefix+"fake"), []byte(fakeHelper), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, ConfigFileName), []byte(fakeConfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+	path := os.Getenv("PATH")
+	defer os.Setenv("PATH", path)
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+
+	cf, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type args struct {
+		serverAddress string
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    AuthConfig
+		wantErr bool
+	}{
+		{"GetAuthConfig1", args{"file.registry"}, AuthConfig{Username: "file-user", Password: "file-pass", Auth: "ZmlsZS11c2VyOmZpbGUtcGFzcw=="}, false},
+		{"GetAuthConfig2", args{"token.registry"}, AuthConfig{IdentityToken: "refresh"}, false},
+		{"GetAuthConfig3", args{"helper.registry"}, AuthConfig{Username: "helper-user", Password: "helper-pass"}, false},
+		{"GetAuthConfig4", args{IndexServer}, AuthConfig{IdentityToken: "identity"}, false},
+		{"GetAuthConfig5", args{"unknown.registry"}, AuthConfig{}, false},
+		{"GetAuthConfig6", args{"broken.registry"}, AuthConfig{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := cf.GetAuthConfig(tt.args.serverAddress)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GetAuthConfig() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("GetAuthConfig() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigFile_StoreSave(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, ConfigFileName), []byte(`{"auths":{},"psFormat":"table {{.ID}}","proxies":{"default":{"httpProxy":"http://proxy:3128"}}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cf, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hub := AuthConfig{Username: "hub-user", Password: "hub-pass"}
+	local := AuthConfig{Username: "local-user", Password: "local-pass"}
+	if err := cf.Store("docker.io", hub); err != nil {
+		t.Fatal(err)
+	}
+	if err := cf.Store("registry.local:5000", local); err != nil {
+		t.Fatal(err)
+	}
+	if err := cf.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := os.Stat(cf.Filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS != "windows" && fi.Mode().Perm() != 0600 {
+		t.Errorf("config mode %v, want 0600", fi.Mode().Perm())
+	}
+
+	b, err := ioutil.ReadFile(cf.Filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(b, &raw); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"psFormat", "proxies"} {
+		if _, ok := raw[key]; !ok {
+			t.Errorf("Save() dropped %s", key)
+		}
+	}
+
+	cf, err = Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cf.AuthConfigs[IndexServer]; !ok || len(cf.AuthConfigs) != 2 {
+		t.Errorf("auths %v, want the Docker Hub credentials under %s", cf.AuthConfigs, IndexServer)
+	}
+
+	tests := []struct {
+		name          string
+		serverAddress string
+		want          AuthConfig
+	}{
+		{"StoreSave1", IndexServer, hub},
+		{"StoreSave2", "index.docker.io", hub},
+		{"StoreSave3", "registry.local:5000", local},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := cf.GetAuthConfig(tt.serverAddress)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Username != tt.want.Username || got.Password != tt.want.Password {
+				t.Errorf("GetAuthConfig() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	if !cf.Erase("index.docker.io") {
+		t.Error("Erase() found nothing to remove")
+	}
+	if cf.Erase("docker.io") {
+		t.Error("Erase() removed the credentials twice")
+	}
+	if err := cf.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	cf, err = Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := cf.GetAuthConfig(IndexServer); got != (AuthConfig{}) {
+		t.Errorf("GetAuthConfig() = %v after Erase", got)
+	}
+	if got, _ := cf.GetAuthConfig("registry.local:5000"); got.Username != local.Username {
+		t.Errorf("Erase() removed the credentials of another registry")
+	}
+}
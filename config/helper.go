@@ -0,0 +1,111 @@
+/*
+Copyright © 2021 myback.space <git@myback.space>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+const (
+	helperPrefix = "docker-credential-"
+	// tokenUsername is the username a helper returns when the secret is an
+	// identity token.
+	tokenUsername = "<token>"
+)
+
+var errCredentialsNotFound = fmt.Errorf("credentials not found in native keychain")
+
+type helperCredentials struct {
+	ServerURL string
+	Username  string
+	Secret    string
+}
+
+// helperGet runs `docker-credential-<helper> get` passing the server address
+// on stdin and reading the credentials as JSON from stdout.
+func helperGet(helper, serverAddress string) (AuthConfig, error) {
+	out, err := helperRun(helper, "get", strings.NewReader(serverAddress))
+	if err != nil {
+		if strings.Contains(string(out), errCredentialsNotFound.Error()) {
+			return AuthConfig{}, errCredentialsNotFound
+		}
+		return AuthConfig{}, err
+	}
+
+	creds := helperCredentials{}
+	if err := json.Unmarshal(out, &creds); err != nil {
+		return AuthConfig{}, fmt.Errorf("%s%s: %s", helperPrefix, helper, err)
+	}
+
+	if creds.Username == tokenUsername {
+		return AuthConfig{IdentityToken: creds.Secret}, nil
+	}
+
+	return AuthConfig{Username: creds.Username, Password: creds.Secret}, nil
+}
+
+// helperStore runs `docker-credential-<helper> store` passing the
+// credentials as JSON on stdin.
+func helperStore(helper, serverAddress string, auth AuthConfig) error {
+	creds := helperCredentials{
+		ServerURL: serverAddress,
+		Username:  auth.Username,
+		Secret:    auth.Password,
+	}
+	if auth.IdentityToken != "" {
+		creds.Username = tokenUsername
+		creds.Secret = auth.IdentityToken
+	}
+
+	b, err := json.Marshal(creds)
+	if err != nil {
+		return err
+	}
+
+	_, err = helperRun(helper, "store", strings.NewReader(string(b)))
+	return err
+}
+
+// helperErase runs `docker-credential-<helper> erase` passing the server
+// address on stdin.
+func helperErase(helper, serverAddress string) error {
+	_, err := helperRun(helper, "erase", strings.NewReader(serverAddress))
+	return err
+}
+
+func helperRun(helper, action string, stdin *strings.Reader) ([]byte, error) {
+	cmd := exec.Command(helperPrefix+helper, action)
+	cmd.Stdin = stdin
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(stdout.String() + stderr.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		return []byte(msg), fmt.Errorf("%s%s %s: %s", helperPrefix, helper, action, msg)
+	}
+
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,76 @@
+/*
+Copyright © 2021 myback.space <git@myback.space>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+const settingsDirName = "docker-pull"
+
+// Settings are the defaults of docker-pull itself kept apart from the
+// Docker client config, command line flags take precedence over them.
+type Settings struct {
+	// RegistryMirrors are asked for Docker Hub images before Docker Hub,
+	// like the key of the same name in the Docker daemon.json.
+	RegistryMirrors []string `json:"registry-mirrors,omitempty"`
+	// InsecureRegistries are host[:port] or CIDR entries of registries
+	// reached without certificate verification or over plain HTTP, like
+	// the insecure-registries of daemon.json.
+	InsecureRegistries []string `json:"insecure-registries,omitempty"`
+	// Proxies override the proxy of a registry host[:port] or host,
+	// "direct" connects it without one.
+	Proxies map[string]string `json:"proxies,omitempty"`
+
+	Filename string `json:"-"`
+}
+
+// SettingsFile returns the location of the docker-pull settings,
+// docker-pull/config.json in the user config directory
+// (~/.config/docker-pull/config.json on Linux).
+func SettingsFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return filepath.Join(settingsDirName, ConfigFileName)
+	}
+
+	return filepath.Join(dir, settingsDirName, ConfigFileName)
+}
+
+// LoadSettings reads the settings from filename. A missing file is not an
+// error, empty settings are returned instead.
+func LoadSettings(filename string) (*Settings, error) {
+	s := &Settings{Filename: filename}
+
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return nil, err
+	}
+
+	if err := json.Unmarshal(b, s); err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+
+	return s, nil
+}
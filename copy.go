@@ -0,0 +1,210 @@
+/*
+Copyright © 2021 myback.space <git@myback.space>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dockerPull
+
+import (
+	"context"
+	"fmt"
+	"hash"
+	"io"
+	"net/url"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/manifest/manifestlist"
+	"github.com/docker/distribution/manifest/schema2"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// CopyTo copies the image of the client to the repository of dst and tags
+// it with the tag of dst. Every blob is streamed from the source registry
+// into the upload to the destination without touching the disk, the ones
+// the destination has already are skipped. Manifests are copied byte for
+// byte, so the image keeps its digest, a manifest list or OCI index with
+// all of its images. The clients may use different credentials.
+func (c *Client) CopyTo(dst *Client) error {
+	return c.CopyToContext(context.Background(), dst)
+}
+
+// CopyToContext is CopyTo bound to ctx, cancelling ctx aborts the copy.
+func (c *Client) CopyToContext(ctx context.Context, dst *Client) error {
+	m, desc, err := c.GetManifestContext(ctx, c.Image.Reference())
+	if err != nil {
+		return err
+	}
+
+	ref := dst.Image.Reference()
+	if d := dst.Image.Digest(); d != "" && d != desc.Digest {
+		return fmt.Errorf("%s: the manifest of %s is %s", dst.Image, c.Image, desc.Digest)
+	}
+
+	fmt.Printf("Copying %s to %s\n", c.Image, dst.Image)
+
+	mediaType, payload, err := c.copyManifest(ctx, dst, m, map[digest.Digest]bool{})
+	if err != nil {
+		return err
+	}
+
+	dgst, err := dst.PutManifestContext(ctx, ref, mediaType, payload)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s: digest: %s size: %d\n", ref, dgst, len(payload))
+
+	return nil
+}
+
+// copyManifest copies what the manifest refers to, the images of a list
+// put by digest, and returns the manifest as it was served. copied keeps
+// the blobs shared by images from being copied again.
+func (c *Client) copyManifest(ctx context.Context, dst *Client, m distribution.Manifest, copied map[digest.Digest]bool) (string, []byte, error) {
+	if list, ok := m.(*manifestlist.DeserializedManifestList); ok {
+		for _, entry := range list.Manifests {
+			child, _, err := c.GetManifestContext(ctx, entry.Digest.String())
+			if err != nil {
+				return "", nil, err
+			}
+
+			mediaType, payload, err := c.copyManifest(ctx, dst, child, copied)
+			if err != nil {
+				return "", nil, err
+			}
+
+			if _, err := dst.PutManifestContext(ctx, entry.Digest.String(), mediaType, payload); err != nil {
+				return "", nil, err
+			}
+		}
+
+		return m.Payload()
+	}
+
+	configDesc, layers, err := imageManifestParts(m)
+	if err != nil {
+		return "", nil, err
+	}
+
+	for _, blob := range append([]distribution.Descriptor{configDesc}, layers...) {
+		if copied[blob.Digest] {
+			continue
+		}
+
+		if err := c.copyBlob(ctx, dst, blob); err != nil {
+			return "", nil, err
+		}
+		copied[blob.Digest] = true
+	}
+
+	return m.Payload()
+}
+
+// copyBlob streams the blob into an upload to dst unless dst has it already
+// or mounts it from the source repository of the same registry. A foreign
+// layer stays where its URLs point to.
+func (c *Client) copyBlob(ctx context.Context, dst *Client, desc distribution.Descriptor) error {
+	id := desc.Digest.Hex()[:12]
+
+	if isForeignLayer(desc.MediaType) {
+		fmt.Printf("%s: Skipped foreign layer\n", id)
+		return nil
+	}
+
+	exists, err := dst.BlobExistsContext(ctx, desc.Digest)
+	if err != nil {
+		return err
+	}
+	if exists {
+		fmt.Printf("%s: Layer already exists\n", id)
+		return nil
+	}
+
+	// The blob is uploaded through the upload session the registry opens
+	// for a failed mount.
+	var location *url.URL
+	if c.Image.Endpoint().Host == dst.Image.Endpoint().Host && c.Image.ns != dst.Image.ns {
+		// A failed mount is no reason to stop, the blob is uploaded.
+		var mounted bool
+		location, mounted, err = dst.mountBlob(ctx, desc.Digest, c.Image.ns)
+		if err != nil && ctx.Err() != nil {
+			return err
+		}
+		if mounted {
+			fmt.Printf("%s: Mounted from %s\n", id, c.Image.ns)
+			return nil
+		}
+	}
+
+	// The stream cannot be rewound, a failed copy starts over with a new
+	// download and upload session.
+	if err := c.retry(ctx, func() error {
+		resp, err := c.GetBlobContext(ctx, desc.Digest, desc.MediaType, 0)
+		if err != nil {
+			if location != nil {
+				dst.cancelUpload(ctx, location)
+				location = nil
+			}
+			return err
+		}
+		defer resp.Body.Close()
+
+		l := location
+		location = nil
+
+		return dst.uploadBlob(ctx, desc, &verifyingReader{
+			r:      resp.Body,
+			hash:   desc.Digest.Algorithm().Hash(),
+			digest: desc.Digest,
+		}, l)
+	}, nil); err != nil {
+		return fmt.Errorf("blob %s: %w", desc.Digest, err)
+	}
+	fmt.Printf("%s: Copied\n", id)
+
+	return nil
+}
+
+func isForeignLayer(mediaType string) bool {
+	switch mediaType {
+	case schema2.MediaTypeForeignLayer,
+		v1.MediaTypeImageLayerNonDistributable,
+		v1.MediaTypeImageLayerNonDistributableGzip,
+		MediaTypeImageLayerNonDistributableZstd:
+		return true
+	}
+
+	return false
+}
+
+// verifyingReader fails the read reaching the end of a stream that does not
+// hash to digest, so the upload it feeds is not completed.
+type verifyingReader struct {
+	r      io.Reader
+	hash   hash.Hash
+	digest digest.Digest
+}
+
+func (v *verifyingReader) Read(p []byte) (int, error) {
+	n, err := v.r.Read(p)
+	v.hash.Write(p[:n])
+
+	if err == io.EOF {
+		if actual := digest.NewDigest(v.digest.Algorithm(), v.hash); actual != v.digest {
+			return n, &ErrDigestMismatch{Blob: v.digest, Expected: v.digest, Actual: actual}
+		}
+	}
+
+	return n, err
+}
@@ -0,0 +1,161 @@
+package dockerPull
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/manifest/manifestlist"
+	"github.com/docker/distribution/manifest/schema2"
+	"github.com/opencontainers/go-digest"
+)
+
+// addImage stores an image with the layers in the repository of reg and
+// returns its manifest descriptor.
+func addImage(t *testing.T, reg *testRegistry, repo string, config []byte, layers ...[]byte) distribution.Descriptor {
+	m := schema2.Manifest{
+		Versioned: schema2.SchemaVersion,
+		Config:    distribution.Descriptor{MediaType: schema2.MediaTypeImageConfig, Digest: reg.addBlob(repo, config), Size: int64(len(config))},
+	}
+	for _, l := range layers {
+		m.Layers = append(m.Layers, distribution.Descriptor{MediaType: schema2.MediaTypeLayer, Digest: reg.addBlob(repo, l), Size: int64(len(l))})
+	}
+
+	dm, err := schema2.FromStruct(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mediaType, payload, _ := dm.Payload()
+	desc := distribution.Descriptor{MediaType: mediaType, Digest: digest.FromBytes(payload), Size: int64(len(payload))}
+	reg.addManifest(repo, desc.Digest.String(), mediaType, payload)
+
+	return desc
+}
+
+func TestClient_CopyTo(t *testing.T) {
+	src := newTestRegistry()
+	defer src.Close()
+
+	shared := []byte("shared layer")
+	amd64 := addImage(t, src, "team/app", []byte(`{"architecture":"amd64"}`), shared, []byte("amd64 layer"))
+	arm64 := addImage(t, src, "team/app", []byte(`{"architecture":"arm64"}`), shared, []byte("arm64 layer"))
+	list, err := manifestlist.FromDescriptors([]manifestlist.ManifestDescriptor{
+		{Descriptor: amd64, Platform: manifestlist.PlatformSpec{OS: "linux", Architecture: "amd64"}},
+		{Descriptor: arm64, Platform: manifestlist.PlatformSpec{OS: "linux", Architecture: "arm64"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mediaType, payload, _ := list.Payload()
+	src.addManifest("team/app", "1.0", mediaType, payload)
+	src.addManifest("team/app", "single", amd64.MediaType, src.manifests["team/app"][amd64.Digest.String()])
+
+	tests := []struct {
+		name      string
+		ref       string
+		chunkSize int64
+		manifests []digest.Digest
+		blobs     int
+	}{
+		{"CopyTo1", "1.0", 0, []digest.Digest{amd64.Digest, arm64.Digest}, 5},
+		{"CopyTo2", "1.0", 4, []digest.Digest{amd64.Digest, arm64.Digest}, 5},
+		{"CopyTo3", "single", 0, nil, 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dst := newTestRegistry()
+			defer dst.Close()
+
+			from := src.client("team/app", 0)
+			from.Image.tag = tt.ref
+			from.SetCredentials("alice", "a")
+			to := dst.client("prod/app", tt.chunkSize)
+			to.Image.tag = "2.0"
+			to.SetCredentials("bob", "b")
+
+			// The second copy finds everything in the destination.
+			for i := 0; i < 2; i++ {
+				if err := from.CopyToContext(context.Background(), to); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if !bytes.Equal(dst.manifests["prod/app"]["2.0"], src.manifests["team/app"][tt.ref]) {
+				t.Errorf("copied manifest %s, want %s", dst.manifests["prod/app"]["2.0"], src.manifests["team/app"][tt.ref])
+			}
+			for _, d := range tt.manifests {
+				if !bytes.Equal(dst.manifests["prod/app"][d.String()], src.manifests["team/app"][d.String()]) {
+					t.Errorf("manifest %s was not copied", d)
+				}
+			}
+			if len(dst.blobs["prod/app"]) != tt.blobs || dst.next != tt.blobs {
+				t.Errorf("got %d blobs in %d uploads, want %d", len(dst.blobs["prod/app"]), dst.next, tt.blobs)
+			}
+			for d, b := range dst.blobs["prod/app"] {
+				if want, _ := src.blob("team/app", d); !bytes.Equal(b, want) {
+					t.Errorf("blob %s holds %q, want %q", d, b, want)
+				}
+			}
+
+			for _, u := range src.users {
+				if u != "alice" {
+					t.Errorf("source registry authenticated %q", u)
+				}
+			}
+			for _, u := range dst.users {
+				if u != "bob" {
+					t.Errorf("destination registry authenticated %q", u)
+				}
+			}
+		})
+	}
+}
+
+func TestClient_CopyToMount(t *testing.T) {
+	reg := newTestRegistry()
+	defer reg.Close()
+
+	desc := addImage(t, reg, "team/app", []byte(`{}`), []byte("layer"))
+
+	from := reg.client("team/app", 0)
+	from.Image.digest = desc.Digest
+	to := reg.client("prod/app", 0)
+	if err := from.CopyToContext(context.Background(), to); err != nil {
+		t.Fatal(err)
+	}
+
+	if reg.mounts != 2 || reg.next != 0 {
+		t.Errorf("got %d mounts and %d uploads, want 2 mounts", reg.mounts, reg.next)
+	}
+	if !bytes.Equal(reg.manifests["prod/app"]["latest"], reg.manifests["team/app"][desc.Digest.String()]) {
+		t.Error("the manifest was not copied")
+	}
+}
+
+func TestClient_CopyToDigestMismatch(t *testing.T) {
+	src := newTestRegistry()
+	defer src.Close()
+	dst := newTestRegistry()
+	defer dst.Close()
+
+	desc := addImage(t, src, "team/app", []byte(`{}`), []byte("layer"))
+	layer := digest.FromBytes([]byte("layer"))
+	src.blobs["team/app"][layer] = []byte("tampered")
+
+	from := src.client("team/app", 0)
+	from.Image.digest = desc.Digest
+	err := from.CopyToContext(context.Background(), dst.client("prod/app", 0))
+
+	var mismatch *ErrDigestMismatch
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("CopyTo() error = %v, want a digest mismatch", err)
+	}
+	if _, ok := dst.blob("prod/app", layer); ok {
+		t.Error("the tampered layer was stored")
+	}
+	if dst.manifests["prod/app"] != nil {
+		t.Error("the manifest was put")
+	}
+}
@@ -17,192 +17,457 @@ limitations under the License.
 package dockerPull
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"mime"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/docker/distribution"
+	"github.com/docker/distribution/manifest"
 	"github.com/docker/distribution/manifest/manifestlist"
+	"github.com/docker/distribution/manifest/ocischema"
 	"github.com/docker/distribution/manifest/schema2"
 	"github.com/docker/docker/image"
 	"github.com/myback/go-docker-pull/archive"
+	"github.com/myback/go-docker-pull/cache"
 	"github.com/myback/go-docker-pull/progressbar"
 	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
 )
 
 var (
 	ErrImageNotFound     = fmt.Errorf("pull access denied, repository does not exist or may require login and password")
 	ErrEmptyManifestList = fmt.Errorf("empty manifest list")
+	ErrUnauthorized      = fmt.Errorf("unauthorized: incorrect username or password")
+	ErrPushDenied        = fmt.Errorf("push access denied, repository does not exist or may require login and password")
 )
 
-type jwtToken struct {
-	Token     string    `json:"token"`
-	ExpiresIn int       `json:"expires_in"`
-	IssuedAt  time.Time `json:"issued_at"`
+// ErrDigestMismatch is returned when downloaded content does not hash to the
+// digest it was requested by. Expected is the digest of the blob itself or
+// the DiffID when the uncompressed layer is verified. Manifest is set when
+// Blob is a manifest pulled by digest.
+type ErrDigestMismatch struct {
+	Blob     digest.Digest
+	Expected digest.Digest
+	Actual   digest.Digest
+	Manifest bool
+}
+
+func (e *ErrDigestMismatch) Error() string {
+	if e.Manifest {
+		return fmt.Sprintf("manifest %s: digest mismatch: got %s", e.Blob, e.Actual)
+	}
+	if e.Blob == e.Expected {
+		return fmt.Sprintf("blob %s: digest mismatch: got %s", e.Blob, e.Actual)
+	}
+
+	return fmt.Sprintf("blob %s: uncompressed digest mismatch: expected %s, got %s", e.Blob, e.Expected, e.Actual)
+}
+
+var (
+	imageManifestMediaTypes = []string{
+		schema2.MediaTypeManifest,
+		v1.MediaTypeImageManifest,
+	}
+	manifestMediaTypes = append([]string{
+		manifestlist.MediaTypeManifestList,
+		v1.MediaTypeImageIndex,
+	}, imageManifestMediaTypes...)
+)
+
+const (
+	compressionNone = ""
+	compressionGzip = ".gz"
+	compressionZstd = ".zst"
+)
+
+// layerCompressions maps the Docker and OCI layer media types onto the
+// compression of the blob, which is also the extension of the downloaded file.
+var layerCompressions = map[string]string{
+	schema2.MediaTypeLayer:                     compressionGzip,
+	schema2.MediaTypeForeignLayer:              compressionGzip,
+	schema2.MediaTypeUncompressedLayer:         compressionNone,
+	v1.MediaTypeImageLayer:                     compressionNone,
+	v1.MediaTypeImageLayerGzip:                 compressionGzip,
+	MediaTypeImageLayerZstd:                    compressionZstd,
+	v1.MediaTypeImageLayerNonDistributable:     compressionNone,
+	v1.MediaTypeImageLayerNonDistributableGzip: compressionGzip,
+	MediaTypeImageLayerNonDistributableZstd:    compressionZstd,
 }
 
 type Client struct {
 	*http.Client
-	Image               *requestedImage
-	token               *jwtToken
+	Image    *requestedImage
+	Progress *progressbar.Pool
+	Retry    RetryPolicy
+	// Cache, if set, is consulted before a layer is downloaded and keeps
+	// every downloaded one.
+	Cache *cache.Store
+	// Mirrors of Docker Hub are asked for manifests and blobs of Docker Hub
+	// images before the registry itself.
+	Mirrors []*url.URL
+	// PageSize asks the paginated lists of tags and repositories for pages
+	// of the given number of entries, the registry default when 0.
+	PageSize int
+	// ChunkSize uploads blobs in PATCH requests of the given number of
+	// bytes, with a single PUT when 0.
+	ChunkSize int64
+
+	auth                *authCache
 	login, password, UA string
+	identityToken       string
+
+	servedMu sync.Mutex
+	served   []ServedBlob
+
+	// layers maps the DiffIDs extracted by GetLayer to their layer.tar, so
+	// a layer shared by several pulled platforms is downloaded once.
+	layersMu sync.Mutex
+	layers   map[digest.Digest]string
 }
 
 func (c *Client) SetCredentials(login, password string) {
 	c.login = login
 	c.password = password
-	c.token = nil
+	c.identityToken = ""
+	c.auth = newAuthCache()
+}
+
+// SetIdentityToken makes the client exchange the OAuth2 refresh token for
+// bearer tokens instead of authenticating with login and password.
+func (c *Client) SetIdentityToken(token string) {
+	c.identityToken = token
+	c.auth = newAuthCache()
 }
 
 func (c *Client) NewGetRequest(url string) (*http.Request, error) {
-	req, err := http.NewRequest("GET", url, nil)
+	return c.NewGetRequestContext(context.Background(), url)
+}
+
+// NewGetRequestContext is NewGetRequest bound to ctx, cancelling ctx aborts
+// the request.
+func (c *Client) NewGetRequestContext(ctx context.Context, url string) (*http.Request, error) {
+	return c.newRequest(ctx, http.MethodGet, url, nil)
+}
+
+func (c *Client) newRequest(ctx context.Context, method, url string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
 	if err != nil {
 		return nil, err
 	}
 
+	req.Header.Set("User-Agent", c.userAgent())
+	return req, nil
+}
+
+func (c *Client) userAgent() string {
 	if c.UA == "" {
-		c.UA = "docker-pull"
+		return "docker-pull"
 	}
 
-	req.Header.Set("User-Agent", c.UA)
-	return req, nil
+	return c.UA
 }
 
-func (c *Client) getToken(url string) error {
-	req, err := c.NewGetRequest(url)
-	if err != nil {
-		return err
-	}
-	resp, err := c.Do(req)
+func (c *Client) get(ctx context.Context, url string, headers http.Header) (*http.Response, error) {
+	req, err := c.NewGetRequestContext(ctx, url)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer resp.Body.Close()
 
-	if c.token == nil {
-		c.token = &jwtToken{}
+	for k, v := range headers {
+		req.Header[k] = v
 	}
 
-	wwwHdr := resp.Header.Get("www-authenticate")
-	if wwwHdr == "" {
-		return nil
+	return c.do(req, c.Image.Scope("pull"))
+}
+
+// do sends the request authorized for scope, several scopes are separated
+// by spaces. The first request to a host goes out anonymously, a 401 answer
+// tells which authentication the host wants and the request is repeated
+// once with it. A request with a body is repeated only if it has GetBody.
+func (c *Client) do(req *http.Request, scope string) (*http.Response, error) {
+	if c.auth == nil {
+		c.auth = newAuthCache()
 	}
 
-	realm := WWWAuthenticateParse(wwwHdr)
-	u, err := realm.Url("")
-	if err != nil {
-		return err
+	if err := c.authorize(req, scope, false); err != nil {
+		return nil, err
 	}
 
-	reqToken, err := c.NewGetRequest(u)
+	resp, err := c.Do(req)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	if c.login != "" {
-		reqToken.SetBasicAuth(c.login, c.password)
+	if resp.StatusCode != http.StatusUnauthorized && resp.StatusCode != http.StatusForbidden {
+		return resp, nil
 	}
+	resp.Body.Close()
 
-	respToken, err := c.Do(reqToken)
-	if err != nil {
-		return err
+	if wwwHdr := resp.Header.Get("WWW-Authenticate"); wwwHdr != "" {
+		c.auth.setChallenge(req.URL.Host, WWWAuthenticateParse(wwwHdr))
 	}
-	defer respToken.Body.Close()
 
-	if err := json.NewDecoder(respToken.Body).Decode(c.token); err != nil {
-		return err
+	if _, ok := c.auth.challenge(req.URL.Host); !ok {
+		return nil, accessDenied(req)
 	}
 
-	return nil
-}
+	if err := c.authorize(req, scope, true); err != nil {
+		return nil, err
+	}
 
-func (c *Client) get(url string, headers http.Header) (*http.Response, error) {
-	// || time.Now().After(c.token.IssuedAt.Add(time.Duration(c.token.ExpiresIn-1)*time.Second))
-	if c.token == nil {
-		if err := c.getToken(url); err != nil {
+	// The body of an upload was consumed by the first attempt.
+	if req.Body != nil && req.Body != http.NoBody {
+		if req.GetBody == nil {
+			return nil, fmt.Errorf("%s %s: the request body cannot be sent again after authentication", req.Method, req.URL.Path)
+		}
+		if req.Body, err = req.GetBody(); err != nil {
 			return nil, err
 		}
 	}
 
-	req, err := c.NewGetRequest(url)
+	resp, err = c.Do(req)
 	if err != nil {
 		return nil, err
 	}
 
-	req.Header = headers
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.token.Token))
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		resp.Body.Close()
+		return nil, accessDenied(req)
+	}
+
+	return resp, nil
+}
 
-	resp, err := c.Do(req)
-	if err == nil {
-		if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
-			if err := c.getToken(url); err != nil {
-				return nil, err
-			}
+// accessDenied is the error of a request refused even after authentication,
+// ErrPushDenied for the requests changing the repository.
+func accessDenied(req *http.Request) error {
+	if req.Method == http.MethodGet || req.Method == http.MethodHead {
+		return ErrImageNotFound
+	}
+
+	return ErrPushDenied
+}
 
-			req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", c.token.Token))
-			resp, err = c.Do(req)
+// getMirrored requests the repository path from the mirrors in order and
+// then from the registry. A mirror failing, missing the content or
+// answering 5xx passes the request on to the next endpoint. The endpoint of
+// the response is returned as well.
+func (c *Client) getMirrored(ctx context.Context, headers http.Header, paths ...string) (*http.Response, string, error) {
+	if c.Image.IsDockerHub() {
+		for _, mirror := range c.Mirrors {
+			resp, err := c.get(ctx, c.Image.urlAt(mirror, paths...), headers)
 			if err != nil {
-				return nil, err
+				if ctx.Err() != nil {
+					return nil, "", err
+				}
+				continue
 			}
-			defer resp.Body.Close()
 
-			if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
-				return nil, ErrImageNotFound
+			if resp.StatusCode != http.StatusNotFound && resp.StatusCode < 500 {
+				return resp, mirror.String(), nil
 			}
+			resp.Body.Close()
 		}
 	}
 
-	return resp, err
+	resp, err := c.get(ctx, c.Image.Url(paths...), headers)
+
+	return resp, c.Image.Endpoint().String(), err
+}
+
+// ServedBlob records the endpoint, mirror or registry, a blob was
+// downloaded from.
+type ServedBlob struct {
+	Digest   digest.Digest
+	Endpoint string
+}
+
+func (c *Client) addServedBlob(dgst digest.Digest, endpoint string) {
+	c.servedMu.Lock()
+	defer c.servedMu.Unlock()
+
+	for _, s := range c.served {
+		if s.Digest == dgst {
+			return
+		}
+	}
+	c.served = append(c.served, ServedBlob{Digest: dgst, Endpoint: endpoint})
+}
+
+// ServedBlobs lists the blobs downloaded by the client in the order they
+// were requested.
+func (c *Client) ServedBlobs() []ServedBlob {
+	c.servedMu.Lock()
+	defer c.servedMu.Unlock()
+
+	return append([]ServedBlob(nil), c.served...)
+}
+
+func (c *Client) authorize(req *http.Request, scope string, refresh bool) error {
+	ch, ok := c.auth.challenge(req.URL.Host)
+	if !ok {
+		return nil
+	}
+
+	switch strings.ToLower(ch.Scheme) {
+	case "basic":
+		if c.login != "" {
+			req.SetBasicAuth(c.login, c.password)
+		}
+	case "bearer":
+		token, err := c.bearerToken(req.Context(), ch, scope, refresh)
+		if err != nil {
+			return err
+		}
+
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	}
+
+	return nil
 }
 
 func (c *Client) GetManifestList() (*manifestlist.ManifestList, error) {
-	list := &manifestlist.ManifestList{}
+	return c.GetManifestListContext(context.Background())
+}
 
-	hdr := http.Header{}
-	hdr.Set("Accept", manifestlist.MediaTypeManifestList)
-	resp, err := c.get(c.Image.ManifestUrl(c.Image.tag), hdr)
+func (c *Client) GetManifestListContext(ctx context.Context) (*manifestlist.ManifestList, error) {
+	manifest, _, err := c.GetManifestContext(ctx, c.Image.Reference())
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
 
-	if err := json.NewDecoder(resp.Body).Decode(list); err != nil {
-		return nil, err
+	list, ok := manifest.(*manifestlist.DeserializedManifestList)
+	if !ok || len(list.Manifests) == 0 {
+		return &manifestlist.ManifestList{}, ErrEmptyManifestList
 	}
 
-	if list.SchemaVersion != 2 || len(list.Manifests) == 0 {
-		return list, ErrEmptyManifestList
-	}
+	return &list.ManifestList, nil
+}
 
-	return list, nil
+// GetManifest requests the manifest by tag or digest accepting both Docker
+// and OCI media types. Depending on the returned Content-Type it is a
+// *manifestlist.DeserializedManifestList (manifest list or OCI index),
+// *schema2.DeserializedManifest or *ocischema.DeserializedManifest.
+func (c *Client) GetManifest(tag string) (distribution.Manifest, distribution.Descriptor, error) {
+	return c.GetManifestContext(context.Background(), tag)
 }
 
-func (c *Client) GetManifest(tag string) (*schema2.Manifest, string, error) {
-	manifest := &schema2.Manifest{}
+func (c *Client) GetManifestContext(ctx context.Context, tag string) (distribution.Manifest, distribution.Descriptor, error) {
+	return c.getManifest(ctx, tag, manifestMediaTypes)
+}
 
+// getManifest requests the manifest of tag, a manifest requested by digest
+// is verified to hash to it.
+func (c *Client) getManifest(ctx context.Context, tag string, mediaTypes []string) (distribution.Manifest, distribution.Descriptor, error) {
 	hdr := http.Header{}
-	hdr.Set("Accept", schema2.MediaTypeManifest)
-	resp, err := c.get(c.Image.ManifestUrl(tag), hdr)
+	for _, mediaType := range mediaTypes {
+		hdr.Add("Accept", mediaType)
+	}
+
+	dgst, err := digest.Parse(tag)
 	if err != nil {
-		return nil, "", err
+		dgst = ""
+	}
+
+	var (
+		contentType string
+		b           []byte
+	)
+	if err := c.retry(ctx, func() error {
+		resp, _, err := c.getMirrored(ctx, hdr, "manifests", tag)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode >= 400 {
+			return newErrHTTPStatus(resp)
+		}
+
+		contentType = resp.Header.Get("Content-Type")
+		if b, err = ioutil.ReadAll(resp.Body); err != nil {
+			return err
+		}
+
+		if dgst != "" {
+			if actual := dgst.Algorithm().FromBytes(b); actual != dgst {
+				return &ErrDigestMismatch{Blob: dgst, Expected: dgst, Actual: actual, Manifest: true}
+			}
+		}
+
+		return nil
+	}, nil); err != nil {
+		return nil, distribution.Descriptor{}, err
+	}
+
+	return unmarshalManifest(contentType, b)
+}
+
+// unmarshalManifest dispatches on the Content-Type returned by the registry.
+// Some registries serve manifests as application/json, then the media type
+// is taken from the payload itself.
+func unmarshalManifest(contentType string, b []byte) (distribution.Manifest, distribution.Descriptor, error) {
+	if mediaType, _, err := mime.ParseMediaType(contentType); err != nil || !isManifestMediaType(mediaType) {
+		var versioned struct {
+			manifest.Versioned
+			Manifests json.RawMessage `json:"manifests"`
+		}
+		if err := json.Unmarshal(b, &versioned); err != nil {
+			return nil, distribution.Descriptor{}, err
+		}
+
+		contentType = versioned.MediaType
+		if contentType == "" {
+			contentType = v1.MediaTypeImageManifest
+			if versioned.Manifests != nil {
+				contentType = v1.MediaTypeImageIndex
+			}
+		}
+	}
+
+	return distribution.UnmarshalManifest(contentType, b)
+}
+
+func isManifestMediaType(mediaType string) bool {
+	for _, mt := range manifestMediaTypes {
+		if mt == mediaType {
+			return true
+		}
 	}
-	defer resp.Body.Close()
 
-	contentDigest := resp.Header.Get("Docker-Content-Digest")
+	return false
+}
 
-	if err := json.NewDecoder(resp.Body).Decode(manifest); err != nil {
-		return nil, "", err
+// imageManifestParts returns the config and layers of a Docker schema2 or
+// OCI image manifest.
+func imageManifestParts(m distribution.Manifest) (distribution.Descriptor, []distribution.Descriptor, error) {
+	switch m := m.(type) {
+	case *schema2.DeserializedManifest:
+		return m.Config, m.Layers, nil
+	case *ocischema.DeserializedManifest:
+		return m.Config, m.Layers, nil
 	}
 
-	return manifest, contentDigest, nil
+	mediaType, _, _ := m.Payload()
+	return distribution.Descriptor{}, nil, fmt.Errorf("unsupported manifest media type: %s", mediaType)
 }
 
 func (c *Client) GetBlob(tag digest.Digest, mediaTypeLayer string, resume int64) (*http.Response, error) {
+	return c.GetBlobContext(context.Background(), tag, mediaTypeLayer, resume)
+}
+
+func (c *Client) GetBlobContext(ctx context.Context, tag digest.Digest, mediaTypeLayer string, resume int64) (*http.Response, error) {
 	hdr := http.Header{}
 	if mediaTypeLayer != "" {
 		hdr.Set("Accept", mediaTypeLayer)
@@ -212,21 +477,27 @@ func (c *Client) GetBlob(tag digest.Digest, mediaTypeLayer string, resume int64)
 		hdr.Set("Range", fmt.Sprintf("bytes=%d-", resume))
 	}
 
-	resp, err := c.get(c.Image.BlobUrl(tag.String()), hdr)
+	resp, endpoint, err := c.getMirrored(ctx, hdr, "blobs", tag.String())
 	if err != nil {
 		return resp, err
 	}
 
 	if resp.StatusCode >= 400 {
 		defer resp.Body.Close()
-		b, _ := ioutil.ReadAll(resp.Body)
-		return nil, fmt.Errorf("status code [%d]: error: \"%s\"", resp.StatusCode, b)
+		return nil, newErrHTTPStatus(resp)
 	}
+	c.addServedBlob(tag, endpoint)
 
 	return resp, err
 }
 
 func (c *Client) GetLayer(dir string, diffId digest.Digest, layerDesc distribution.Descriptor, legacyImg image.V1Image, created time.Time) error {
+	return c.GetLayerContext(context.Background(), dir, diffId, layerDesc, legacyImg, created)
+}
+
+// GetLayerContext is GetLayer bound to ctx. Cancelling ctx stops the
+// download and the extraction, the partly extracted layer.tar is removed.
+func (c *Client) GetLayerContext(ctx context.Context, dir string, diffId digest.Digest, layerDesc distribution.Descriptor, legacyImg image.V1Image, created time.Time) error {
 	legacyFilesList := []string{"", legacyVersionFileName, legacyConfigFileName, legacyLayerFileName}
 	outDir := filepath.Join(dir, legacyImg.ID)
 
@@ -246,11 +517,16 @@ func (c *Client) GetLayer(dir string, diffId digest.Digest, layerDesc distributi
 		return err
 	}
 
+	compression, ok := layerCompressions[layerDesc.MediaType]
+	if !ok {
+		return fmt.Errorf("%s: unsupported layer media type: %s", layerDesc.Digest, layerDesc.MediaType)
+	}
+
 	layerFilePath := filepath.Join(outDir, legacyLayerFileName)
-	tmpLayer := layerFilePath + ".gz"
+	tmpLayer := layerFilePath + compression + ".part"
 	shortLayerTag := layerDesc.Digest.Hex()[:12]
 
-	bar := progressbar.NewProgressBar(50)
+	bar := c.progressBar(shortLayerTag)
 	defer bar.Close()
 
 	if _, err := os.Stat(layerFilePath); err != nil {
@@ -264,6 +540,8 @@ func (c *Client) GetLayer(dir string, diffId digest.Digest, layerDesc distributi
 		}
 
 		if ok {
+			c.addExtractedLayer(diffId, layerFilePath)
+
 			bar.SetDescription(fmt.Sprintf("%s: %s ", shortLayerTag, "Pull complete"))
 			bar.Flush()
 
@@ -271,52 +549,259 @@ func (c *Client) GetLayer(dir string, diffId digest.Digest, layerDesc distributi
 		}
 	}
 
-	var resume int64
-	if fInfo, err := os.Stat(tmpLayer); err != nil {
-		if !os.IsNotExist(err) {
-			return err
-		}
-	} else {
-		ok, err := FileHashEqual(tmpLayer, layerDesc.Digest.Hex())
-		if err != nil {
+	// The layer is copied rather than linked, chtimes would otherwise set
+	// the times of the layer.tar of another image sharing the inode.
+	if src, ok := c.extractedLayer(diffId); ok {
+		if err := copyFile(src, layerFilePath); err != nil {
 			return err
 		}
+		c.addExtractedLayer(diffId, layerFilePath)
 
-		if ok {
-			return gunzipLayer(layerFilePath, tmpLayer, shortLayerTag, bar)
+		// The progress line is the one of the blob and tells its status
+		// already.
+		return chtimes(outDir, legacyFilesList, created)
+	}
+
+	cached, err := c.downloadBlobCached(ctx, tmpLayer, layerDesc, bar)
+	if err != nil {
+		return err
+	}
+
+	if err := decompressLayer(ctx, layerFilePath, tmpLayer, compression, layerDesc.Digest, diffId, bar); err != nil {
+		var mismatchErr *ErrDigestMismatch
+		if cached && errors.As(err, &mismatchErr) {
+			c.Cache.Remove(layerDesc.Digest)
 		}
+		return err
+	}
+	c.addExtractedLayer(diffId, layerFilePath)
+
+	status := "Pull complete"
+	if cached {
+		status = "Already exists"
+	}
+	bar.SetDescription(fmt.Sprintf("%s: %s ", shortLayerTag, status))
+	bar.Flush()
+
+	return chtimes(outDir, legacyFilesList, created)
+}
+
+func (c *Client) extractedLayer(diffId digest.Digest) (string, bool) {
+	c.layersMu.Lock()
+	defer c.layersMu.Unlock()
+
+	path, ok := c.layers[diffId]
+
+	return path, ok
+}
 
-		resume = fInfo.Size()
+func (c *Client) addExtractedLayer(diffId digest.Digest, path string) {
+	c.layersMu.Lock()
+	defer c.layersMu.Unlock()
+
+	if c.layers == nil {
+		c.layers = map[digest.Digest]string{}
+	}
+	if _, ok := c.layers[diffId]; !ok {
+		c.layers[diffId] = path
+	}
+}
+
+// GetLayerBlob stores the layer in dir/blobs/<algorithm>/<hex> compressed as
+// served by the registry, which is how the OCI image layout keeps it.
+func (c *Client) GetLayerBlob(dir string, layerDesc distribution.Descriptor) error {
+	return c.GetLayerBlobContext(context.Background(), dir, layerDesc)
+}
+
+func (c *Client) GetLayerBlobContext(ctx context.Context, dir string, layerDesc distribution.Descriptor) error {
+	blobPath := ociBlobPath(dir, layerDesc.Digest)
+	if err := os.MkdirAll(filepath.Dir(blobPath), os.ModePerm); err != nil {
+		return err
 	}
 
-	resp, err := c.GetBlob(layerDesc.Digest, layerDesc.MediaType, resume)
+	shortLayerTag := layerDesc.Digest.Hex()[:12]
+
+	bar := c.progressBar(shortLayerTag)
+	defer bar.Close()
+
+	cached, err := c.downloadBlobCached(ctx, blobPath, layerDesc, bar)
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
 
-	outputFile, err := os.OpenFile(tmpLayer, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	status := "Pull complete"
+	if cached {
+		status = "Already exists"
+	}
+	bar.SetDescription(fmt.Sprintf("%s: %s ", shortLayerTag, status))
+	bar.Flush()
+
+	return nil
+}
+
+// downloadBlobCached is downloadBlob taking the blob from Cache when it is
+// there and adding it otherwise. It reports whether the blob came from the
+// cache. The blob lock keeps concurrent pulls from fetching it twice.
+func (c *Client) downloadBlobCached(ctx context.Context, path string, desc distribution.Descriptor, bar *progressbar.ProgressBar) (bool, error) {
+	if c.Cache == nil {
+		return false, c.downloadBlob(ctx, path, desc, bar)
+	}
+
+	unlock, err := c.Cache.Lock(ctx, desc.Digest)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	ok, err := c.Cache.Get(desc.Digest, desc.Size, path)
+	if err != nil || ok {
+		return ok, err
+	}
+
+	if err := c.downloadBlob(ctx, path, desc, bar); err != nil {
+		return false, err
+	}
+
+	return false, c.Cache.Put(desc.Digest, path)
+}
+
+// downloadBlob fetches the blob into path verifying its digest while the
+// data streams in. A file left by a previous run is hashed once to seed the
+// digester and the download resumes from its size, so does every retry of a
+// dropped transfer. On a digest mismatch the file is removed and
+// *ErrDigestMismatch is returned.
+func (c *Client) downloadBlob(ctx context.Context, path string, desc distribution.Descriptor, bar *progressbar.ProgressBar) error {
+	outputFile, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
 	if err != nil {
 		return err
 	}
 	defer outputFile.Close()
 
+	digester := desc.Digest.Algorithm().Digester()
+	offset, err := io.Copy(digester.Hash(), outputFile)
+	if err != nil {
+		return err
+	}
+
+	if desc.Size > 0 && offset >= desc.Size {
+		if digester.Digest() == desc.Digest {
+			return nil
+		}
+
+		if offset, err = restartBlob(outputFile, digester); err != nil {
+			return err
+		}
+	}
+
+	err = c.retry(ctx, func() error {
+		offset, err = c.fetchBlob(ctx, outputFile, digester, desc, offset, bar)
+		return err
+	}, func(d time.Duration) {
+		bar.SetDescription(fmt.Sprintf("%s: Retrying in %s ", desc.Digest.Hex()[:12], d.Round(time.Second)))
+		bar.Flush()
+	})
+
+	var mismatchErr *ErrDigestMismatch
+	if errors.As(err, &mismatchErr) {
+		outputFile.Close()
+		os.Remove(path)
+	}
+
+	return err
+}
+
+// fetchBlob appends the blob to f from offset and returns the new offset.
+// The file is started over when the registry does not honor the range or
+// the content does not match the digest.
+func (c *Client) fetchBlob(ctx context.Context, f *os.File, digester digest.Digester, desc distribution.Descriptor, offset int64, bar *progressbar.ProgressBar) (int64, error) {
+	resp, err := c.GetBlobContext(ctx, desc.Digest, desc.MediaType, offset)
+	if err != nil {
+		return offset, err
+	}
+	defer resp.Body.Close()
+
+	if offset > 0 {
+		if resp.StatusCode == http.StatusPartialContent {
+			if err := checkContentRange(resp, offset); err != nil {
+				_, rErr := restartBlob(f, digester)
+				if rErr != nil {
+					return offset, rErr
+				}
+				return 0, err
+			}
+		} else if offset, err = restartBlob(f, digester); err != nil {
+			// The registry ignored the Range header and sends the whole blob.
+			return offset, err
+		}
+	}
+
 	bar.ContentLength(resp.ContentLength)
-	bar.SetDescription(fmt.Sprintf("%s: %s ", shortLayerTag, "Downloading"))
+	bar.SetDescription(fmt.Sprintf("%s: %s ", desc.Digest.Hex()[:12], "Downloading"))
 
 	buff := make([]byte, 131072)
-	if _, err := io.CopyBuffer(io.MultiWriter(outputFile, bar), resp.Body, buff); err != nil {
-		return err
+	n, err := io.CopyBuffer(io.MultiWriter(f, digester.Hash(), bar), resp.Body, buff)
+	offset += n
+	if err != nil {
+		return offset, err
 	}
 
-	if err := gunzipLayer(layerFilePath, tmpLayer, shortLayerTag, bar); err != nil {
-		return err
+	if actual := digester.Digest(); actual != desc.Digest {
+		if _, err := restartBlob(f, digester); err != nil {
+			return offset, err
+		}
+		return 0, &ErrDigestMismatch{Blob: desc.Digest, Expected: desc.Digest, Actual: actual}
 	}
 
-	bar.SetDescription(fmt.Sprintf("%s: %s ", shortLayerTag, "Pull complete"))
-	bar.Flush()
+	return offset, nil
+}
 
-	return chtimes(outDir, legacyFilesList, created)
+func restartBlob(f *os.File, digester digest.Digester) (int64, error) {
+	if err := f.Truncate(0); err != nil {
+		return 0, err
+	}
+
+	digester.Hash().Reset()
+	return f.Seek(0, io.SeekStart)
+}
+
+// GetBlobBytes reads the whole blob into memory, which is meant for small
+// blobs like the image config, and verifies it against desc.Digest.
+func (c *Client) GetBlobBytes(desc distribution.Descriptor) ([]byte, error) {
+	return c.GetBlobBytesContext(context.Background(), desc)
+}
+
+func (c *Client) GetBlobBytesContext(ctx context.Context, desc distribution.Descriptor) ([]byte, error) {
+	var b []byte
+	err := c.retry(ctx, func() error {
+		resp, err := c.GetBlobContext(ctx, desc.Digest, desc.MediaType, 0)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if b, err = ioutil.ReadAll(resp.Body); err != nil {
+			return err
+		}
+
+		if actual := desc.Digest.Algorithm().FromBytes(b); actual != desc.Digest {
+			return &ErrDigestMismatch{Blob: desc.Digest, Expected: desc.Digest, Actual: actual}
+		}
+
+		return nil
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+func (c *Client) progressBar(id string) *progressbar.ProgressBar {
+	if c.Progress == nil {
+		return progressbar.NewProgressBar(50)
+	}
+
+	return c.Progress.Bar(id)
 }
 
 func chtimes(dir string, fileList []string, created time.Time) error {
@@ -329,20 +814,60 @@ func chtimes(dir string, fileList []string, created time.Time) error {
 	return nil
 }
 
-func gunzipLayer(dst, src, tag string, bar *progressbar.ProgressBar) error {
-	gz := archive.NewGzip(dst, src)
+// decompressLayer unpacks the layer blob src into dst verifying the
+// uncompressed stream against diffId, an uncompressed blob is copied. Both
+// files are removed on a mismatch, dst is removed as well when ctx is
+// cancelled.
+func decompressLayer(ctx context.Context, dst, src, compression string, blob, diffId digest.Digest, bar *progressbar.ProgressBar) error {
+	if compression == compressionNone {
+		if blob != diffId {
+			os.Remove(src)
+			return &ErrDigestMismatch{Blob: blob, Expected: diffId, Actual: blob}
+		}
 
-	size, err := gz.GetUnarchSize()
-	if err != nil {
-		return err
+		// src may be a hardlink of the cache blob, dst gets an inode of
+		// its own so setting its times leaves the cache LRU alone.
+		if err := copyFile(src, dst); err != nil {
+			os.Remove(dst)
+			return err
+		}
+
+		return os.Remove(src)
+	}
+
+	var size int64
+	if compression == compressionGzip {
+		gzSize, err := archive.NewGzip(dst, src).GetUnarchSize()
+		if err != nil {
+			return err
+		}
+		size = int64(gzSize)
 	}
-	bar.ContentLength(int64(size))
-	bar.SetDescription(fmt.Sprintf("%s: %s ", tag, "Extracting"))
+
+	bar.ContentLength(size)
+	bar.SetDescription(fmt.Sprintf("%s: %s ", blob.Hex()[:12], "Extracting"))
 	bar.Flush()
 
-	if _, err := gz.GunZip(bar); err != nil {
+	digester := diffId.Algorithm().Digester()
+
+	var err error
+	switch compression {
+	case compressionGzip:
+		_, err = archive.NewGzip(dst, src).GunZip(bar, digester.Hash(), contextWriter{ctx})
+	case compressionZstd:
+		_, err = archive.NewZstd(dst, src).Decompress(bar, digester.Hash(), contextWriter{ctx})
+	}
+	if err != nil {
+		os.Remove(dst)
 		return err
 	}
 
+	if actual := digester.Digest(); actual != diffId {
+		os.Remove(dst)
+		os.Remove(src)
+
+		return &ErrDigestMismatch{Blob: blob, Expected: diffId, Actual: actual}
+	}
+
 	return os.Remove(src)
 }
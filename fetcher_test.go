@@ -0,0 +1,302 @@
+package dockerPull
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/manifest/schema2"
+	"github.com/docker/docker/image"
+	"github.com/myback/go-docker-pull/cache"
+	"github.com/myback/go-docker-pull/progressbar"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestClient_GetBlobMirrors(t *testing.T) {
+	blob := []byte("layer")
+	dgst := digest.FromBytes(blob)
+
+	serve := func(status int) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/v2/library/alpine/blobs/"+dgst.String() || status != http.StatusOK {
+				w.WriteHeader(status)
+				return
+			}
+			w.Write(blob)
+		}))
+	}
+	missing, broken, mirror := serve(http.StatusNotFound), serve(http.StatusBadGateway), serve(http.StatusOK)
+	defer missing.Close()
+	defer broken.Close()
+	defer mirror.Close()
+
+	tests := []struct {
+		name    string
+		mirrors []string
+		want    string
+	}{
+		{"GetBlobMirrors1", []string{mirror.URL}, mirror.URL},
+		{"GetBlobMirrors2", []string{missing.URL, mirror.URL}, mirror.URL},
+		{"GetBlobMirrors3", []string{broken.URL, "http://127.0.0.1:1", mirror.URL}, mirror.URL},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			img, err := ParseRequestedImage("alpine")
+			if err != nil {
+				t.Fatal(err)
+			}
+			c := &Client{Client: &http.Client{}, Image: img}
+			for _, m := range tt.mirrors {
+				u, err := url.Parse(m)
+				if err != nil {
+					t.Fatal(err)
+				}
+				c.Mirrors = append(c.Mirrors, u)
+			}
+
+			resp, err := c.GetBlobContext(context.Background(), dgst, "", 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, err := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if err != nil || string(b) != string(blob) {
+				t.Fatalf("GetBlob() = %q, %v", b, err)
+			}
+
+			served := c.ServedBlobs()
+			if len(served) != 1 || served[0].Endpoint != tt.want {
+				t.Errorf("ServedBlobs() = %v, want %s", served, tt.want)
+			}
+		})
+	}
+}
+
+func TestClient_GetManifestByDigest(t *testing.T) {
+	payload := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.docker.distribution.manifest.v2+json",` +
+		`"config":{"mediaType":"application/vnd.docker.container.image.v1+json","size":2,"digest":"sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a"},"layers":[]}`)
+	dgst := digest.FromBytes(payload)
+	other := digest.FromString("other")
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/vnd.docker.distribution.manifest.v2+json")
+		w.Write(payload)
+	}))
+	defer srv.Close()
+	host, _ := url.Parse(srv.URL)
+
+	tests := []struct {
+		name    string
+		ref     string
+		wantErr bool
+	}{
+		{"GetManifestByDigest1", dgst.String(), false},
+		{"GetManifestByDigest2", other.String(), true},
+		{"GetManifestByDigest3", "latest", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The plain HTTP test server is reached by the HTTP fallback of
+			// loopback registries.
+			c := &Client{
+				Client: (&RegistryClient{CertsDirs: []string{}}).httpClient(""),
+				Image:  &requestedImage{registryHost: host.Host, ns: "alpine"},
+				Retry:  RetryPolicy{Attempts: 1},
+			}
+
+			_, desc, err := c.GetManifestContext(context.Background(), tt.ref)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetManifest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && desc.Digest != dgst {
+				t.Errorf("GetManifest() digest = %s, want %s", desc.Digest, dgst)
+			}
+		})
+	}
+}
+
+func Test_decompressLayerUncompressed(t *testing.T) {
+	store, err := cache.Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := []byte("uncompressed layer")
+	dgst := digest.FromBytes(data)
+	blob := filepath.Join(t.TempDir(), "blob")
+	if err := ioutil.WriteFile(blob, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put(dgst, blob); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "layer.tar.part")
+	if ok, err := store.Get(dgst, int64(len(data)), src); err != nil || !ok {
+		t.Fatalf("Get() = %v, %v", ok, err)
+	}
+
+	dst := filepath.Join(dir, legacyLayerFileName)
+	if err := decompressLayer(context.Background(), dst, src, compressionNone, dgst, dgst, progressbar.NewProgressBar(50)); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(dst, time.Unix(0, 0), time.Unix(0, 0)); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := store.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || time.Since(entries[0].LastUsed) > time.Hour {
+		t.Errorf("cache entries %v, want the blob used just now", entries)
+	}
+	if b, err := ioutil.ReadFile(dst); err != nil || string(b) != string(data) {
+		t.Errorf("layer.tar holds %q, %v", b, err)
+	}
+}
+
+func gzipBytes(t *testing.T, b []byte) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestClient_GetLayerDigestMismatch(t *testing.T) {
+	layer := []byte("layer.tar content")
+	gzLayer := gzipBytes(t, layer)
+
+	tests := []struct {
+		name      string
+		mediaType string
+		blob      digest.Digest
+		diffID    digest.Digest
+		served    []byte
+		expected  digest.Digest
+	}{
+		{"GetLayerDigestMismatch1", schema2.MediaTypeLayer, digest.FromBytes(gzLayer), digest.FromBytes(layer), gzipBytes(t, []byte("corrupted")), digest.FromBytes(gzLayer)},
+		{"GetLayerDigestMismatch2", schema2.MediaTypeLayer, digest.FromBytes(gzLayer), digest.FromString("other"), gzLayer, digest.FromString("other")},
+		{"GetLayerDigestMismatch3", schema2.MediaTypeUncompressedLayer, digest.FromBytes(layer), digest.FromBytes(layer), []byte("corrupted"), digest.FromBytes(layer)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write(tt.served)
+			}))
+			defer srv.Close()
+
+			u, _ := url.Parse(srv.URL)
+			c := &Client{
+				Client: (&RegistryClient{CertsDirs: []string{}}).httpClient(""),
+				Image:  &requestedImage{registryHost: u.Host, ns: "test", tag: "latest"},
+				Retry:  RetryPolicy{Attempts: 1},
+			}
+
+			dir := t.TempDir()
+			desc := distribution.Descriptor{MediaType: tt.mediaType, Digest: tt.blob, Size: int64(len(tt.served))}
+			err := c.GetLayerContext(context.Background(), dir, tt.diffID, desc, image.V1Image{ID: "layer"}, time.Now())
+
+			var mismatch *ErrDigestMismatch
+			if !errors.As(err, &mismatch) {
+				t.Fatalf("GetLayer() error = %v, want *ErrDigestMismatch", err)
+			}
+			if mismatch.Blob != tt.blob || mismatch.Expected != tt.expected {
+				t.Errorf("GetLayer() error = %+v, want blob %s expected %s", mismatch, tt.blob, tt.expected)
+			}
+
+			files, _ := filepath.Glob(filepath.Join(dir, "layer", legacyLayerFileName+"*"))
+			if len(files) != 0 {
+				t.Errorf("GetLayer() left %v", files)
+			}
+		})
+	}
+}
+
+func TestClient_GetBlobBytesDigestMismatch(t *testing.T) {
+	config := []byte(`{"architecture":"amd64"}`)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"architecture":"arm64"}`))
+	}))
+	defer srv.Close()
+
+	u, _ := url.Parse(srv.URL)
+	c := &Client{
+		Client: (&RegistryClient{CertsDirs: []string{}}).httpClient(""),
+		Image:  &requestedImage{registryHost: u.Host, ns: "test", tag: "latest"},
+		Retry:  RetryPolicy{Attempts: 2},
+	}
+
+	desc := distribution.Descriptor{MediaType: schema2.MediaTypeImageConfig, Digest: digest.FromBytes(config), Size: int64(len(config))}
+	b, err := c.GetBlobBytesContext(context.Background(), desc)
+
+	var mismatch *ErrDigestMismatch
+	if !errors.As(err, &mismatch) || mismatch.Blob != desc.Digest {
+		t.Fatalf("GetBlobBytes() error = %v, want *ErrDigestMismatch of %s", err, desc.Digest)
+	}
+	if b != nil {
+		t.Errorf("GetBlobBytes() = %s, want nil", b)
+	}
+}
+
+func TestClient_GetLayerReuse(t *testing.T) {
+	layer := []byte("shared layer.tar")
+	gzLayer := gzipBytes(t, layer)
+
+	var gets int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gets++
+		w.Write(gzLayer)
+	}))
+	defer srv.Close()
+
+	u, _ := url.Parse(srv.URL)
+	c := &Client{
+		Client: (&RegistryClient{CertsDirs: []string{}}).httpClient(""),
+		Image:  &requestedImage{registryHost: u.Host, ns: "test", tag: "latest"},
+		Retry:  RetryPolicy{Attempts: 1},
+	}
+
+	desc := distribution.Descriptor{MediaType: schema2.MediaTypeLayer, Digest: digest.FromBytes(gzLayer), Size: int64(len(gzLayer))}
+	created := []time.Time{time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)}
+	var paths []string
+	for _, c2 := range created {
+		dir := t.TempDir()
+		if err := c.GetLayerContext(context.Background(), dir, digest.FromBytes(layer), desc, image.V1Image{ID: "layer"}, c2); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, filepath.Join(dir, "layer", legacyLayerFileName))
+	}
+
+	if gets != 1 {
+		t.Errorf("GetLayer() downloaded the layer %d times, want once", gets)
+	}
+
+	// The layer.tar of the first image keeps its own time.
+	for i, path := range paths {
+		fi, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !fi.ModTime().Equal(created[i]) {
+			t.Errorf("GetLayer() %s modified at %s, want %s", path, fi.ModTime(), created[i])
+		}
+	}
+}
@@ -0,0 +1,132 @@
+/*
+Copyright © 2021 myback.space <git@myback.space>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dockerPull
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/docker/distribution/manifest/manifestlist"
+	"github.com/docker/docker/image"
+	"github.com/opencontainers/go-digest"
+)
+
+// ImageInspect describes an image like skopeo inspect does, from its
+// manifests and configs only. The layers are not downloaded.
+type ImageInspect struct {
+	Name      string
+	Digest    digest.Digest
+	MediaType string
+	// Platforms are the images of a manifest list, empty for a reference
+	// to a single image.
+	Platforms []Platform `json:",omitempty"`
+	Images    []InspectedImage
+}
+
+// InspectedImage is one image manifest with its decoded config.
+type InspectedImage struct {
+	Platform     Platform
+	Digest       digest.Digest
+	MediaType    string
+	ConfigDigest digest.Digest
+	Layers       []InspectedLayer
+	Config       *image.Image
+}
+
+type InspectedLayer struct {
+	Digest    digest.Digest
+	Size      int64
+	MediaType string
+}
+
+func (rc *RegistryClient) Inspect(imageReq *requestedImage) (*ImageInspect, error) {
+	return rc.InspectContext(context.Background(), imageReq)
+}
+
+// InspectContext resolves the reference and fetches the manifests and
+// configs of the images Pull would save: the platforms of Platforms or
+// AllPlatforms, otherwise the one of Arch and OS.
+func (rc *RegistryClient) InspectContext(ctx context.Context, imageReq *requestedImage) (*ImageInspect, error) {
+	fetcher, err := rc.NewClient(imageReq)
+	if err != nil {
+		return nil, err
+	}
+
+	m, desc, err := fetcher.GetManifestContext(ctx, imageReq.Reference())
+	if err != nil {
+		return nil, err
+	}
+
+	info := &ImageInspect{
+		Name:      imageReq.String(),
+		Digest:    desc.Digest,
+		MediaType: desc.MediaType,
+	}
+	if list, ok := m.(*manifestlist.DeserializedManifestList); ok {
+		info.Platforms = availablePlatforms(list.Manifests)
+	}
+
+	images, err := rc.selectImages(ctx, fetcher, m, desc)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, img := range images {
+		configDesc, layers, err := imageManifestParts(img.manifest)
+		if err != nil {
+			return nil, err
+		}
+
+		b, err := fetcher.GetBlobBytesContext(ctx, configDesc)
+		if err != nil {
+			return nil, err
+		}
+
+		config := &image.Image{}
+		if err := json.Unmarshal(b, config); err != nil {
+			return nil, err
+		}
+
+		inspected := InspectedImage{
+			Platform:     img.platform,
+			Digest:       img.desc.Digest,
+			MediaType:    img.desc.MediaType,
+			ConfigDigest: configDesc.Digest,
+			Config:       config,
+		}
+		if img.spec == nil {
+			// A single image tells its platform by the config only.
+			inspected.Platform = Platform{
+				OS:           config.OS,
+				Architecture: config.Architecture,
+				Variant:      config.Variant,
+				OSVersion:    config.OSVersion,
+			}
+		}
+		for _, l := range layers {
+			inspected.Layers = append(inspected.Layers, InspectedLayer{
+				Digest:    l.Digest,
+				Size:      l.Size,
+				MediaType: l.MediaType,
+			})
+		}
+
+		info.Images = append(info.Images, inspected)
+	}
+
+	return info, nil
+}
@@ -0,0 +1,168 @@
+package dockerPull
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/manifest/manifestlist"
+	"github.com/docker/distribution/manifest/schema2"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestRegistryClient_Inspect(t *testing.T) {
+	configs := map[string][]byte{
+		"amd64": []byte(`{"architecture":"amd64","os":"linux","config":{"Env":["PATH=/bin"]},"rootfs":{"type":"layers","diff_ids":[]}}`),
+		"arm64": []byte(`{"architecture":"arm64","variant":"v8","os":"linux","config":{"Env":["PATH=/usr/bin"]},"rootfs":{"type":"layers","diff_ids":[]}}`),
+	}
+	layer := distribution.Descriptor{MediaType: schema2.MediaTypeLayer, Size: 3, Digest: digest.FromString("foo")}
+
+	blobs := map[digest.Digest][]byte{}
+	manifests := map[string][]byte{}
+	var entries []manifestlist.ManifestDescriptor
+	for _, arch := range []string{"amd64", "arm64"} {
+		configDigest := digest.FromBytes(configs[arch])
+		blobs[configDigest] = configs[arch]
+
+		m, err := schema2.FromStruct(schema2.Manifest{
+			Versioned: schema2.SchemaVersion,
+			Config:    distribution.Descriptor{MediaType: schema2.MediaTypeImageConfig, Size: int64(len(configs[arch])), Digest: configDigest},
+			Layers:    []distribution.Descriptor{layer},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, payload, _ := m.Payload()
+		manifests[digest.FromBytes(payload).String()] = payload
+		if arch == "arm64" {
+			manifests["single"] = payload
+		}
+
+		spec := manifestlist.PlatformSpec{OS: "linux", Architecture: arch}
+		if arch == "arm64" {
+			spec.Variant = "v8"
+		}
+		entries = append(entries, manifestlist.ManifestDescriptor{
+			Descriptor: distribution.Descriptor{MediaType: schema2.MediaTypeManifest, Size: int64(len(payload)), Digest: digest.FromBytes(payload)},
+			Platform:   spec,
+		})
+	}
+	list, err := manifestlist.FromDescriptors(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, listPayload, _ := list.Payload()
+	manifests["latest"] = listPayload
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(r.URL.Path, "/")
+		ref := parts[len(parts)-1]
+		switch parts[len(parts)-2] {
+		case "manifests":
+			b, ok := manifests[ref]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			var versioned struct{ MediaType string }
+			json.Unmarshal(b, &versioned)
+			w.Header().Set("Content-Type", versioned.MediaType)
+			w.Write(b)
+		case "blobs":
+			if b, ok := blobs[digest.Digest(ref)]; ok {
+				w.Write(b)
+				return
+			}
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	host, _ := url.Parse(srv.URL)
+
+	tests := []struct {
+		name    string
+		rc      *RegistryClient
+		want    []Platform
+		wantEnv []string
+	}{
+		{"Inspect1", &RegistryClient{OS: "linux", Arch: "amd64"}, []Platform{{OS: "linux", Architecture: "amd64"}}, []string{"PATH=/bin"}},
+		{"Inspect2", &RegistryClient{Platforms: []Platform{{OS: "linux", Architecture: "arm64", Variant: "v8"}}}, []Platform{{OS: "linux", Architecture: "arm64", Variant: "v8"}}, []string{"PATH=/usr/bin"}},
+		{"Inspect3", &RegistryClient{AllPlatforms: true}, []Platform{{OS: "linux", Architecture: "amd64"}, {OS: "linux", Architecture: "arm64", Variant: "v8"}}, []string{"PATH=/bin", "PATH=/usr/bin"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.rc.CertsDirs = []string{}
+			img, err := ParseRequestedImage(host.Host + "/test")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			info, err := tt.rc.InspectContext(context.Background(), img)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if info.Digest != digest.FromBytes(listPayload) || len(info.Platforms) != 2 {
+				t.Errorf("Inspect() = %s %v, want the manifest list", info.Digest, info.Platforms)
+			}
+
+			var platforms []Platform
+			var env []string
+			for _, i := range info.Images {
+				platforms = append(platforms, i.Platform)
+				env = append(env, i.Config.Config.Env...)
+				if len(i.Layers) != 1 || i.Layers[0].Digest != layer.Digest || i.Layers[0].Size != layer.Size {
+					t.Errorf("Inspect() layers = %v, want %v", i.Layers, layer)
+				}
+			}
+			if !reflect.DeepEqual(platforms, tt.want) || !reflect.DeepEqual(env, tt.wantEnv) {
+				t.Errorf("Inspect() = %v %v, want %v %v", platforms, env, tt.want, tt.wantEnv)
+			}
+		})
+	}
+
+	t.Run("InspectSingle", func(t *testing.T) {
+		img, err := ParseRequestedImage(host.Host + "/test:single")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The platform of a single image is the one of its config, not
+		// the requested one.
+		rc := &RegistryClient{OS: "linux", Arch: "amd64", CertsDirs: []string{}}
+		info, err := rc.InspectContext(context.Background(), img)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}
+		if info.Digest != digest.FromBytes(manifests["single"]) || info.Platforms != nil {
+			t.Errorf("Inspect() = %s %v, want the image manifest", info.Digest, info.Platforms)
+		}
+		if len(info.Images) != 1 || info.Images[0].Platform != want || info.Images[0].Digest != info.Digest {
+			t.Errorf("Inspect() images = %+v, want one of %s", info.Images, want)
+		}
+	})
+
+	t.Run("InspectPlatformNotFound", func(t *testing.T) {
+		img, err := ParseRequestedImage(host.Host + "/test")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rc := &RegistryClient{Platforms: []Platform{{OS: "linux", Architecture: "s390x"}}, CertsDirs: []string{}}
+		_, err = rc.InspectContext(context.Background(), img)
+
+		var notFound *ErrPlatformNotFound
+		if !errors.As(err, &notFound) || notFound.Platform.Architecture != "s390x" {
+			t.Errorf("Inspect() error = %v, want *ErrPlatformNotFound of s390x", err)
+		}
+	})
+}
@@ -0,0 +1,174 @@
+/*
+Copyright © 2021 myback.space <git@myback.space>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dockerPull
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// Tags lists the tags of the repository, all pages of /v2/<name>/tags/list.
+func (c *Client) Tags() ([]string, error) {
+	return c.TagsContext(context.Background())
+}
+
+func (c *Client) TagsContext(ctx context.Context) ([]string, error) {
+	var tags []string
+	err := c.getList(ctx, c.Image.Url("tags", "list"), c.Image.Scope("pull"), func(b []byte) ([]string, error) {
+		var page struct {
+			Tags []string `json:"tags"`
+		}
+		if err := json.Unmarshal(b, &page); err != nil {
+			return nil, err
+		}
+		tags = append(tags, page.Tags...)
+
+		return page.Tags, nil
+	})
+
+	return tags, err
+}
+
+// Catalog lists the repositories of the registry, all pages of
+// /v2/_catalog. Registries grant the registry:catalog:* scope it needs to
+// their administrators only, Docker Hub not at all.
+func (c *Client) Catalog() ([]string, error) {
+	return c.CatalogContext(context.Background())
+}
+
+func (c *Client) CatalogContext(ctx context.Context) ([]string, error) {
+	u := c.Image.Endpoint()
+	u.Path = path.Join(u.Path, "v2", "_catalog")
+
+	var repos []string
+	err := c.getList(ctx, u.String(), "registry:catalog:*", func(b []byte) ([]string, error) {
+		var page struct {
+			Repositories []string `json:"repositories"`
+		}
+		if err := json.Unmarshal(b, &page); err != nil {
+			return nil, err
+		}
+		repos = append(repos, page.Repositories...)
+
+		return page.Repositories, nil
+	})
+
+	return repos, err
+}
+
+// getList requests a paginated list of the registry API authorized for
+// scope and passes every page to decode, which returns the entries of the
+// page. The next page is the one of the Link header, a registry sending no
+// Link is asked for the entries after the last one while it returns full
+// pages of PageSize. A page already read ends the list, so a Link pointing
+// back does not loop forever.
+func (c *Client) getList(ctx context.Context, rawurl, scope string, decode func([]byte) ([]string, error)) error {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return err
+	}
+	if c.PageSize > 0 {
+		u.RawQuery = url.Values{"n": {strconv.Itoa(c.PageSize)}}.Encode()
+	}
+
+	visited := map[string]bool{}
+	for u != nil && !visited[u.String()] {
+		visited[u.String()] = true
+
+		var (
+			b    []byte
+			link string
+		)
+		if err := c.retry(ctx, func() error {
+			req, err := c.NewGetRequestContext(ctx, u.String())
+			if err != nil {
+				return err
+			}
+
+			resp, err := c.do(req, scope)
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode >= 400 {
+				return newErrHTTPStatus(resp)
+			}
+
+			link = resp.Header.Get("Link")
+			b, err = ioutil.ReadAll(resp.Body)
+			return err
+		}, nil); err != nil {
+			return err
+		}
+
+		entries, err := decode(b)
+		if err != nil {
+			return err
+		}
+
+		next := u
+		u = nil
+		if link != "" {
+			if u, err = nextLink(next, link); err != nil {
+				return err
+			}
+		} else if c.PageSize > 0 && len(entries) == c.PageSize {
+			last := entries[len(entries)-1]
+			if q := next.Query(); q.Get("last") != last {
+				q.Set("last", last)
+				u = &url.URL{}
+				*u = *next
+				u.RawQuery = q.Encode()
+			}
+		}
+	}
+
+	return nil
+}
+
+// nextLink returns the target of the rel="next" link of an RFC 5988 Link
+// header resolved against base, nil when there is none.
+func nextLink(base *url.URL, header string) (*url.URL, error) {
+	for _, link := range strings.Split(header, ",") {
+		parts := strings.Split(link, ";")
+		target := strings.TrimSpace(parts[0])
+		if !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+			continue
+		}
+
+		for _, param := range parts[1:] {
+			kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+			if len(kv) != 2 || !strings.EqualFold(kv[0], "rel") {
+				continue
+			}
+
+			for _, rel := range strings.Fields(strings.Trim(kv[1], `"`)) {
+				if strings.EqualFold(rel, "next") {
+					return base.Parse(target[1 : len(target)-1])
+				}
+			}
+		}
+	}
+
+	return nil, nil
+}
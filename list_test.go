@@ -0,0 +1,206 @@
+package dockerPull
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+// pagedList serves a list of the registry API, e.g. tags/list, as key in
+// pages of n after last, with a Link header to the next page when link is
+// set.
+func TestClient_TagsLinkLoop(t *testing.T) {
+	tests := []struct {
+		name  string
+		pages map[string]string
+		want  []string
+	}{
+		{"TagsLinkLoop1", map[string]string{"": ""}, []string{"a"}},
+		{"TagsLinkLoop2", map[string]string{"": "b", "b": ""}, []string{"a", "b"}},
+		{"TagsLinkLoop3", map[string]string{"": "b", "b": "c", "c": "b"}, []string{"a", "b", "c"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Every page has one tag and links the page of pages[last].
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				last := r.URL.Query().Get("last")
+				next := r.URL.Path
+				if l := tt.pages[last]; l != "" {
+					next += "?last=" + l
+				}
+				tag := last
+				if tag == "" {
+					tag = "a"
+				}
+
+				w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, next))
+				json.NewEncoder(w).Encode(map[string]interface{}{"tags": []string{tag}})
+			}))
+			defer srv.Close()
+
+			u, _ := url.Parse(srv.URL)
+			c := &Client{
+				Client: (&RegistryClient{CertsDirs: []string{}}).httpClient(""),
+				Image:  &requestedImage{registryHost: u.Host, ns: "test"},
+				Retry:  RetryPolicy{Attempts: 1},
+			}
+
+			got, err := c.TagsContext(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Tags() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func pagedList(key string, tags []string, link bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		n, err := strconv.Atoi(q.Get("n"))
+		if err != nil {
+			n = len(tags)
+		}
+
+		start := sort.SearchStrings(tags, q.Get("last"))
+		if q.Get("last") != "" && start < len(tags) && tags[start] == q.Get("last") {
+			start++
+		}
+		end := start + n
+		if end > len(tags) {
+			end = len(tags)
+		}
+
+		if link && end < len(tags) {
+			w.Header().Set("Link", fmt.Sprintf(`<%s?n=%d&last=%s>; rel="next"`, r.URL.Path, n, tags[end-1]))
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{key: tags[start:end]})
+	}
+}
+
+func TestClient_Tags(t *testing.T) {
+	tags := []string{"1.0.0", "1.1.0", "1.2.0", "2.0.0", "latest"}
+
+	withLink := httptest.NewServer(pagedList("tags", tags, true))
+	defer withLink.Close()
+	withoutLink := httptest.NewServer(pagedList("tags", tags, false))
+	defer withoutLink.Close()
+
+	tests := []struct {
+		name     string
+		url      string
+		pageSize int
+	}{
+		{"Tags1", withLink.URL, 0},
+		{"Tags2", withLink.URL, 2},
+		{"Tags3", withLink.URL, 1},
+		{"Tags4", withoutLink.URL, 2},
+		{"Tags5", withoutLink.URL, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, _ := url.Parse(tt.url)
+			c := &Client{
+				Client:   (&RegistryClient{CertsDirs: []string{}}).httpClient(""),
+				Image:    &requestedImage{registryHost: u.Host, ns: "test"},
+				Retry:    RetryPolicy{Attempts: 1},
+				PageSize: tt.pageSize,
+			}
+
+			got, err := c.TagsContext(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tags) {
+				t.Errorf("Tags() = %v, want %v", got, tags)
+			}
+		})
+	}
+}
+
+func Test_nextLink(t *testing.T) {
+	base, _ := url.Parse("https://registry.local/v2/test/tags/list?n=2")
+
+	tests := []struct {
+		name   string
+		header string
+		want   string
+	}{
+		{"nextLink1", `</v2/test/tags/list?n=2&last=b>; rel="next"`, "https://registry.local/v2/test/tags/list?n=2&last=b"},
+		{"nextLink2", `<https://other.local/v2/test/tags/list?last=b>; rel=next`, "https://other.local/v2/test/tags/list?last=b"},
+		{"nextLink3", `</v2/test/tags/list?last=a>; rel="prev", </v2/test/tags/list?last=c>; rel="next"`, "https://registry.local/v2/test/tags/list?last=c"},
+		{"nextLink4", `</v2/test/tags/list?last=a>; rel="prev"`, ""},
+		{"nextLink5", `garbage`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := nextLink(base, tt.header)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var s string
+			if got != nil {
+				s = got.String()
+			}
+			if s != tt.want {
+				t.Errorf("nextLink() = %v, want %v", s, tt.want)
+			}
+		})
+	}
+}
+
+func TestClient_Catalog(t *testing.T) {
+	repos := []string{"library/alpine", "library/busybox", "team/app"}
+
+	var scopes []string
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			scopes = append(scopes, r.URL.Query().Get("scope"))
+			json.NewEncoder(w).Encode(map[string]string{"token": "catalog"})
+			return
+		}
+
+		if r.Header.Get("Authorization") != "Bearer catalog" {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test"`, srv.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if r.URL.Path != "/v2/_catalog" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		pagedList("repositories", repos, true)(w, r)
+	}))
+	defer srv.Close()
+	u, _ := url.Parse(srv.URL)
+
+	c := &Client{
+		Client:   (&RegistryClient{CertsDirs: []string{}}).httpClient(""),
+		Image:    ParseRegistry(u.Host),
+		Retry:    RetryPolicy{Attempts: 1},
+		PageSize: 2,
+	}
+
+	got, err := c.CatalogContext(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, repos) {
+		t.Errorf("Catalog() = %v, want %v", got, repos)
+	}
+	if len(scopes) != 1 || scopes[0] != "registry:catalog:*" {
+		t.Errorf("Catalog() token scopes = %v, want registry:catalog:*", scopes)
+	}
+}
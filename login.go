@@ -0,0 +1,87 @@
+/*
+Copyright © 2021 myback.space <git@myback.space>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dockerPull
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Authenticate checks the credentials of the RegistryClient against the registry,
+// the empty address or config.IndexServer means Docker Hub.
+func (rc *RegistryClient) Authenticate(serverAddress string) error {
+	return rc.AuthenticateContext(context.Background(), serverAddress)
+}
+
+func (rc *RegistryClient) AuthenticateContext(ctx context.Context, serverAddress string) error {
+	c, err := rc.NewClient(ParseRegistry(serverAddress))
+	if err != nil {
+		return err
+	}
+
+	return c.LoginContext(ctx)
+}
+
+// Login asks the /v2/ endpoint of the registry for its challenge and
+// authenticates the way a pull does: a token is requested from the realm of
+// a Bearer challenge, a Basic one is answered with the credentials directly.
+func (c *Client) Login() error {
+	return c.LoginContext(context.Background())
+}
+
+func (c *Client) LoginContext(ctx context.Context) error {
+	req, err := c.NewGetRequestContext(ctx, c.Image.Url()+"/")
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusUnauthorized {
+		if resp.StatusCode >= 400 {
+			return fmt.Errorf("status code [%d]: %s", resp.StatusCode, req.URL)
+		}
+		return nil
+	}
+
+	ch := WWWAuthenticateParse(resp.Header.Get("WWW-Authenticate"))
+	switch strings.ToLower(ch.Scheme) {
+	case "bearer":
+		_, err := c.getToken(ctx, ch, "")
+		return err
+	case "basic":
+		req.SetBasicAuth(c.login, c.password)
+		resp, err := c.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode == http.StatusUnauthorized {
+			return ErrUnauthorized
+		}
+		return nil
+	}
+
+	return fmt.Errorf("unsupported authentication scheme %q", ch.Scheme)
+}
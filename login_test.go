@@ -0,0 +1,74 @@
+package dockerPull
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// loginRegistry answers /v2/ with the challenge of scheme accepting the
+// user alice with the password secret.
+func loginRegistry(scheme string) *httptest.Server {
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, _ := r.BasicAuth()
+		valid := user == "alice" && pass == "secret"
+
+		switch {
+		case r.URL.Path == "/token":
+			if !valid {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]string{"token": "login"})
+		case scheme == "":
+		case scheme == "Basic" && valid:
+		case scheme == "Bearer":
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test"`, srv.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+		default:
+			w.Header().Set("WWW-Authenticate", scheme+` realm="test"`)
+			w.WriteHeader(http.StatusUnauthorized)
+		}
+	}))
+
+	return srv
+}
+
+func TestClient_Login(t *testing.T) {
+	tests := []struct {
+		name     string
+		scheme   string
+		password string
+		wantErr  error
+	}{
+		{"Login1", "Bearer", "secret", nil},
+		{"Login2", "Bearer", "wrong", ErrUnauthorized},
+		{"Login3", "Basic", "secret", nil},
+		{"Login4", "Basic", "wrong", ErrUnauthorized},
+		{"Login5", "", "wrong", nil},
+		{"Login6", "Negotiate", "secret", fmt.Errorf(`unsupported authentication scheme "Negotiate"`)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := loginRegistry(tt.scheme)
+			defer srv.Close()
+
+			u, _ := url.Parse(srv.URL)
+			c := &Client{
+				Client: (&RegistryClient{CertsDirs: []string{}}).httpClient(""),
+				Image:  ParseRegistry(u.Host),
+			}
+			c.SetCredentials("alice", tt.password)
+
+			err := c.LoginContext(context.Background())
+			if fmt.Sprint(err) != fmt.Sprint(tt.wantErr) {
+				t.Errorf("Login() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
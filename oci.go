@@ -0,0 +1,182 @@
+/*
+Copyright © 2021 myback.space <git@myback.space>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dockerPull
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/manifest/manifestlist"
+	"github.com/docker/distribution/manifest/ocischema"
+	"github.com/docker/distribution/manifest/schema2"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	ociBlobsDir      = "blobs"
+	ociIndexFileName = "index.json"
+)
+
+// OCI layer media types missing in image-spec v1.0.1.
+const (
+	MediaTypeImageLayerZstd                 = "application/vnd.oci.image.layer.v1.tar+zstd"
+	MediaTypeImageLayerNonDistributableZstd = "application/vnd.oci.image.layer.nondistributable.v1.tar+zstd"
+)
+
+// ociMediaTypes maps the Docker media types onto their OCI counterparts.
+var ociMediaTypes = map[string]string{
+	schema2.MediaTypeImageConfig:       v1.MediaTypeImageConfig,
+	schema2.MediaTypeLayer:             v1.MediaTypeImageLayerGzip,
+	schema2.MediaTypeForeignLayer:      v1.MediaTypeImageLayerNonDistributableGzip,
+	schema2.MediaTypeUncompressedLayer: v1.MediaTypeImageLayer,
+}
+
+// saveOCILayout writes the images into dir following the OCI image layout:
+// oci-layout, index.json and the blobs/sha256 store. Layers are kept
+// compressed as served by the registry, an OCI manifest is stored unchanged
+// and a Docker one is converted. Blobs shared by the images are fetched once.
+func (rc *RegistryClient) saveOCILayout(ctx context.Context, fetcher *Client, dir string, images []platformImage) error {
+	var jobs []layerJob
+	for _, img := range images {
+		configDesc, layers, err := imageManifestParts(img.manifest)
+		if err != nil {
+			return err
+		}
+
+		imageRepoBytes, err := fetcher.GetBlobBytesContext(ctx, configDesc)
+		if err != nil {
+			return err
+		}
+
+		if err := writeOCIBlob(dir, configDesc.Digest, imageRepoBytes); err != nil {
+			return err
+		}
+
+		for _, layerDesc := range layers {
+			jobs = append(jobs, layerJob{layerDesc: layerDesc})
+		}
+	}
+
+	// The same blob may be referenced by several layers, it is stored once.
+	jobs, _ = splitRepeatedLayers(jobs)
+	if err := rc.fetchLayers(ctx, fetcher, jobs, func(ctx context.Context, job layerJob) error {
+		return fetcher.GetLayerBlobContext(ctx, dir, job.layerDesc)
+	}); err != nil {
+		return err
+	}
+
+	index := v1.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		Manifests: make([]v1.Descriptor, 0, len(images)),
+	}
+	for _, img := range images {
+		imageManifest := img.manifest
+		if m, ok := imageManifest.(*schema2.DeserializedManifest); ok {
+			var err error
+			if imageManifest, err = ocischema.FromStruct(ociManifest(&m.Manifest)); err != nil {
+				return err
+			}
+		}
+
+		mediaType, payload, err := imageManifest.Payload()
+		if err != nil {
+			return err
+		}
+
+		manifestDigest := digest.FromBytes(payload)
+		if err := writeOCIBlob(dir, manifestDigest, payload); err != nil {
+			return err
+		}
+
+		desc := v1.Descriptor{
+			MediaType: mediaType,
+			Digest:    manifestDigest,
+			Size:      int64(len(payload)),
+			Platform:  ociPlatform(img.spec),
+		}
+		// Tools resolving the tag pick an arbitrary entry of several, so
+		// only a single image is annotated with it.
+		if tag := fetcher.Image.Tag(); tag != "" && len(images) == 1 {
+			desc.Annotations = map[string]string{v1.AnnotationRefName: tag}
+		}
+		index.Manifests = append(index.Manifests, desc)
+	}
+
+	if err := SaveToJson(filepath.Join(dir, v1.ImageLayoutFile), v1.ImageLayout{
+		Version: v1.ImageLayoutVersion,
+	}); err != nil {
+		return err
+	}
+
+	return SaveToJson(filepath.Join(dir, ociIndexFileName), index)
+}
+
+// ociManifest converts a Docker schema2 manifest into an OCI one. The
+// config and layer blobs are left untouched, only media types change.
+func ociManifest(m *schema2.Manifest) ocischema.Manifest {
+	out := ocischema.Manifest{
+		Versioned: ocischema.SchemaVersion,
+		Config:    ociDescriptor(m.Config),
+		Layers:    make([]distribution.Descriptor, 0, len(m.Layers)),
+	}
+
+	for _, l := range m.Layers {
+		out.Layers = append(out.Layers, ociDescriptor(l))
+	}
+
+	return out
+}
+
+func ociPlatform(spec *manifestlist.PlatformSpec) *v1.Platform {
+	if spec == nil {
+		return nil
+	}
+
+	return &v1.Platform{
+		Architecture: spec.Architecture,
+		OS:           spec.OS,
+		OSVersion:    spec.OSVersion,
+		OSFeatures:   spec.OSFeatures,
+		Variant:      spec.Variant,
+	}
+}
+
+func ociDescriptor(desc distribution.Descriptor) distribution.Descriptor {
+	if mediaType, ok := ociMediaTypes[desc.MediaType]; ok {
+		desc.MediaType = mediaType
+	}
+
+	return desc
+}
+
+func writeOCIBlob(dir string, dgst digest.Digest, data []byte) error {
+	blobPath := ociBlobPath(dir, dgst)
+	if err := os.MkdirAll(filepath.Dir(blobPath), os.ModePerm); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(blobPath, data, 0644)
+}
+
+func ociBlobPath(dir string, dgst digest.Digest) string {
+	return filepath.Join(dir, ociBlobsDir, dgst.Algorithm().String(), dgst.Hex())
+}
@@ -0,0 +1,69 @@
+package dockerPull
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/manifest/manifestlist"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestRegistryClient_saveOCILayoutRefName(t *testing.T) {
+	reg := newTestRegistry()
+	defer reg.Close()
+
+	var images []platformImage
+	for _, arch := range []string{"amd64", "arm64"} {
+		layer := gzipBytes(t, []byte(arch+" layer"))
+		desc := addImage(t, reg, "test", []byte(`{"architecture":"`+arch+`"}`), layer)
+		m, _, err := distribution.UnmarshalManifest(desc.MediaType, reg.manifests["test"][desc.Digest.String()])
+		if err != nil {
+			t.Fatal(err)
+		}
+		images = append(images, platformImage{
+			spec:     &manifestlist.PlatformSpec{OS: "linux", Architecture: arch},
+			desc:     desc,
+			manifest: m,
+		})
+	}
+
+	tests := []struct {
+		name    string
+		images  []platformImage
+		refName []string
+	}{
+		{"saveOCILayoutRefName1", images[:1], []string{"latest"}},
+		{"saveOCILayoutRefName2", images, []string{"", ""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			rc := &RegistryClient{}
+			if err := rc.saveOCILayout(context.Background(), reg.client("test", 0), dir, tt.images); err != nil {
+				t.Fatal(err)
+			}
+
+			b, err := ioutil.ReadFile(filepath.Join(dir, ociIndexFileName))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var index v1.Index
+			if err := json.Unmarshal(b, &index); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(index.Manifests) != len(tt.refName) {
+				t.Fatalf("saveOCILayout() wrote %d manifests, want %d", len(index.Manifests), len(tt.refName))
+			}
+			for i, m := range index.Manifests {
+				if got := m.Annotations[v1.AnnotationRefName]; got != tt.refName[i] {
+					t.Errorf("saveOCILayout() ref.name of %s = %q, want %q", m.Digest, got, tt.refName[i])
+				}
+			}
+		})
+	}
+}
@@ -18,41 +18,73 @@ package dockerPull
 
 import (
 	"fmt"
+	"net/url"
 	"os"
 	"path"
+	"regexp"
 	"strings"
 
+	"github.com/docker/distribution/reference"
 	"github.com/docker/docker/registry"
+	"github.com/myback/go-docker-pull/config"
+	"github.com/opencontainers/go-digest"
 )
 
 const (
 	officialRepoName = "library"
 	defaultTag       = "latest"
+	// dockerHubDomain is the domain of Docker Hub references after
+	// normalization.
+	dockerHubDomain = "docker.io"
 )
 
+var anchoredTagRegexp = regexp.MustCompile(`^` + reference.TagRegexp.String() + `$`)
+
 type requestedImage struct {
-	insecure     bool
 	registryHost string
 	ns           string
 	tag          string
-	tempDir      string
+	// digest pins the image, the manifest is requested by it and tag only
+	// names the written image.
+	digest digest.Digest
+	// implicitTag is set when the reference had neither tag nor digest and
+	// the latest tag is pulled.
+	implicitTag bool
+	tempDir     string
+	// platform tells apart the output of one of several pulled platforms.
+	platform  string
+	platforms []*requestedImage
 }
 
 func (ri *requestedImage) Url(paths ...string) string {
-	u := registry.DefaultV2Registry
+	return ri.urlAt(ri.Endpoint(), paths...)
+}
+
+// Endpoint is the base URL of the registry.
+func (ri *requestedImage) Endpoint() *url.URL {
+	u := *registry.DefaultV2Registry
 
-	if ri.insecure {
-		u.Scheme = "http"
-	}
 	if ri.registryHost != "" {
 		u.Host = ri.registryHost
 	}
-	u.Path = path.Join("v2", ri.ns)
+
+	return &u
+}
+
+// urlAt is Url of the repository at another endpoint, e.g. a mirror.
+func (ri *requestedImage) urlAt(endpoint *url.URL, paths ...string) string {
+	u := *endpoint
+	u.Path = path.Join(u.Path, "v2", ri.ns)
 	u.Path = path.Join(append([]string{u.Path}, paths...)...)
 
 	return u.String()
 }
 
+// IsDockerHub reports whether the image comes from Docker Hub.
+func (ri *requestedImage) IsDockerHub() bool {
+	return ri.registryHost == "" || config.ConvertToHostname(ri.registryHost) == "index.docker.io"
+}
+
 func (ri *requestedImage) ManifestUrl(tag string) string {
 	return ri.Url("manifests", tag)
 }
@@ -61,17 +93,90 @@ func (ri *requestedImage) BlobUrl(tag string) string {
 	return ri.Url("blobs", tag)
 }
 
+// Scope is the token scope granting actions on the repository, e.g.
+// repository:library/alpine:pull.
+func (ri *requestedImage) Scope(actions ...string) string {
+	return fmt.Sprintf("repository:%s:%s", ri.ns, strings.Join(actions, ","))
+}
+
+// ServerAddress is the key the registry credentials are stored under in the
+// Docker client config.
+func (ri *requestedImage) ServerAddress() string {
+	if ri.registryHost == "" {
+		return config.IndexServer
+	}
+
+	return ri.registryHost
+}
+
 func (ri *requestedImage) Tag() string {
 	return ri.tag
 }
 
-func (ri *requestedImage) InsecureRegistry() {
-	ri.insecure = false
+func (ri *requestedImage) Digest() digest.Digest {
+	return ri.digest
+}
+
+// IsNameOnly reports whether the image was requested by its repository
+// alone, with neither tag nor digest.
+func (ri *requestedImage) IsNameOnly() bool {
+	return ri.implicitTag
+}
+
+// SetTag names an image pulled by digest in the written archive, it has no
+// tag otherwise.
+func (ri *requestedImage) SetTag(tag string) error {
+	if ri.digest == "" {
+		return fmt.Errorf("a tag can only be set for an image pulled by digest")
+	}
+	if !anchoredTagRegexp.MatchString(tag) {
+		return fmt.Errorf("invalid tag %q", tag)
+	}
+	ri.tag = tag
+
+	return nil
+}
+
+// Domain is the registry part of a full name, docker.io for Docker Hub.
+func (ri *requestedImage) Domain() string {
+	if ri.IsDockerHub() {
+		return dockerHubDomain
+	}
+
+	return ri.registryHost
+}
+
+// Name is the full name of the repository, e.g. docker.io/library/alpine.
+func (ri *requestedImage) Name() string {
+	return ri.Domain() + "/" + ri.ns
+}
+
+// String is the full reference of the image, e.g.
+// docker.io/library/alpine:3.10.
+func (ri *requestedImage) String() string {
+	s := ri.Name()
+	if ri.tag != "" {
+		s += ":" + ri.tag
+	}
+	if ri.digest != "" {
+		s += "@" + ri.digest.String()
+	}
+
+	return s
+}
+
+// Reference is what the manifest is requested by: the digest of an image
+// pulled by digest, the tag otherwise.
+func (ri *requestedImage) Reference() string {
+	if ri.digest != "" {
+		return ri.digest.String()
+	}
+
+	return ri.tag
 }
 
 func (ri *requestedImage) OutputImageName() string {
-	return fmt.Sprintf("%s_%s.tar", strings.ReplaceAll(ri.ns, "/", "_"),
-		strings.ReplaceAll(ri.tag, "-", "_"))
+	return ri.fileName() + ".tar"
 }
 
 func (ri *requestedImage) TempDir() string {
@@ -79,35 +184,90 @@ func (ri *requestedImage) TempDir() string {
 }
 
 func (ri *requestedImage) TempDirCreate() (string, error) {
-	ri.tempDir = fmt.Sprintf("%s_%s.tmp", strings.ReplaceAll(ri.ns, "/", "_"),
-		strings.ReplaceAll(ri.tag, "-", "_"))
+	ri.tempDir = ri.fileName() + ".tmp"
 
 	return ri.tempDir, os.MkdirAll(ri.tempDir, os.ModePerm)
 }
 
-func ParseRequestedImage(s string) *requestedImage {
+func (ri *requestedImage) fileName() string {
+	ref := ri.tag
+	if ref == "" {
+		ref = strings.ReplaceAll(ri.digest.String(), ":", "_")
+	}
+	name := fmt.Sprintf("%s_%s", strings.ReplaceAll(ri.ns, "/", "_"),
+		strings.ReplaceAll(ref, "-", "_"))
+	if ri.platform != "" {
+		name += "_" + ri.platform
+	}
+
+	return name
+}
+
+// Outputs returns the images the last Pull has written: the request itself
+// or one image per platform when several were pulled in the docker format.
+func (ri *requestedImage) Outputs() []*requestedImage {
+	if len(ri.platforms) > 0 {
+		return ri.platforms
+	}
+
+	return []*requestedImage{ri}
+}
+
+// forPlatform returns a copy of the request writing into a temp dir and an
+// archive of its own named after the platform.
+func (ri *requestedImage) forPlatform(p Platform) *requestedImage {
+	out := *ri
+	out.tempDir = ""
+	out.platforms = nil
+	p.OSVersion = ""
+	out.platform = strings.ReplaceAll(p.String(), "/", "_")
+
+	return &out
+}
+
+// ParseRegistry returns the request of a registry as a whole, e.g. for its
+// catalog. The address is a host[:port] or a URL, the empty one or
+// config.IndexServer means Docker Hub.
+func ParseRegistry(serverAddress string) *requestedImage {
+	ri := &requestedImage{}
+	if serverAddress != "" && serverAddress != config.IndexServer {
+		ri.registryHost = config.ConvertToHostname(serverAddress)
+	}
+
+	return ri
+}
+
+// ParseRequestedImage parses an image reference of the distribution
+// grammar, [domain[:port]/]path[:tag][@digest]. The first path component is
+// the domain when it contains a dot or a port or is localhost, Docker Hub
+// otherwise, where a single component path is an official image. A
+// reference with neither tag nor digest pulls the latest tag.
+func ParseRequestedImage(s string) (*requestedImage, error) {
+	named, err := reference.ParseNormalizedNamed(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid reference %q: %s", s, err)
+	}
+
 	ri := &requestedImage{
-		tag: defaultTag,
+		ns: reference.Path(named),
 	}
 
-	idx := strings.IndexByte(s, '/')
-	if idx > -1 && strings.IndexAny(s[:idx], ".:") > -1 {
-		ri.registryHost = s[:idx]
-		s = s[idx+1:]
+	if domain := reference.Domain(named); domain != dockerHubDomain {
+		ri.registryHost = domain
 	}
 
-	idx = strings.IndexAny(s, "@:")
-	if idx > -1 {
-		ri.tag = s[idx+1:]
-		s = s[:idx]
+	if tagged, ok := named.(reference.Tagged); ok {
+		ri.tag = tagged.Tag()
 	}
 
-	idx = strings.IndexByte(s, '/')
-	if idx == -1 && ri.registryHost == "" {
-		s = officialRepoName + "/" + s
+	if canonical, ok := named.(reference.Canonical); ok {
+		ri.digest = canonical.Digest()
 	}
 
-	ri.ns = s
+	if ri.tag == "" && ri.digest == "" {
+		ri.tag = defaultTag
+		ri.implicitTag = true
+	}
 
-	return ri
+	return ri, nil
 }
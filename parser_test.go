@@ -2,42 +2,93 @@ package dockerPull
 
 import (
 	"reflect"
+	"strings"
 	"testing"
 )
 
+const testDigest = "sha256:a143f3ba578f79e2c7b3022c488e6e12a35836cd4a6eb9e363d7f3a07d848590"
+
 func TestParseRequestedImage(t *testing.T) {
 	type args struct {
 		s string
 	}
 	tests := []struct {
-		name string
-		args args
-		want *requestedImage
+		name    string
+		args    args
+		want    *requestedImage
+		wantErr bool
 	}{
-		{"ParseRequestedImage1", args{"alpine"}, &requestedImage{ns: "library/alpine", tag: defaultTag}},
-		{"ParseRequestedImage2", args{"alpine:1.13"}, &requestedImage{ns: "library/alpine", tag: "1.13"}},
-		{"ParseRequestedImage2", args{"alpine@sha256:abcdefgh"}, &requestedImage{ns: "library/alpine", tag: "sha256:abcdefgh"}},
-		{"ParseRequestedImage2", args{"ns/alpine"}, &requestedImage{ns: "ns/alpine", tag: defaultTag}},
-		{"ParseRequestedImage3", args{"ns/alpine:1.13"}, &requestedImage{ns: "ns/alpine", tag: "1.13"}},
-		{"ParseRequestedImage2", args{"ns/alpine@sha256:abcdefgh"}, &requestedImage{ns: "ns/alpine", tag: "sha256:abcdefgh"}},
-		{"ParseRequestedImage4", args{"private.registry/alpine"}, &requestedImage{registryHost: "private.registry", ns: "alpine", tag: defaultTag}},
-		{"ParseRequestedImage5", args{"private.registry/ns/alpine"}, &requestedImage{registryHost: "private.registry", ns: "ns/alpine", tag: defaultTag}},
-		{"ParseRequestedImage6", args{"private.registry/ns/alpine:1.13"}, &requestedImage{registryHost: "private.registry", ns: "ns/alpine", tag: "1.13"}},
-		{"ParseRequestedImage2", args{"private.registry/ns/alpine@sha256:abcdefgh"}, &requestedImage{registryHost: "private.registry", ns: "ns/alpine", tag: "sha256:abcdefgh"}},
-		{"ParseRequestedImage7", args{"private.registry:8443/alpine"}, &requestedImage{registryHost: "private.registry:8443", ns: "alpine", tag: defaultTag}},
-		{"ParseRequestedImage8", args{"private.registry:8443/ns/alpine"}, &requestedImage{registryHost: "private.registry:8443", ns: "ns/alpine", tag: defaultTag}},
-		{"ParseRequestedImage9", args{"private.registry:8443/ns/alpine:1.13"}, &requestedImage{registryHost: "private.registry:8443", ns: "ns/alpine", tag: "1.13"}},
-		{"ParseRequestedImage2", args{"private.registry:8443/ns/alpine@sha256:abcdefgh"}, &requestedImage{registryHost: "private.registry:8443", ns: "ns/alpine", tag: "sha256:abcdefgh"}},
+		{"ParseRequestedImage1", args{"alpine"}, &requestedImage{ns: "library/alpine", tag: defaultTag, implicitTag: true}, false},
+		{"ParseRequestedImage2", args{"alpine:1.13"}, &requestedImage{ns: "library/alpine", tag: "1.13"}, false},
+		{"ParseRequestedImage3", args{"alpine@" + testDigest}, &requestedImage{ns: "library/alpine", digest: testDigest}, false},
+		{"ParseRequestedImage4", args{"ns/alpine"}, &requestedImage{ns: "ns/alpine", tag: defaultTag, implicitTag: true}, false},
+		{"ParseRequestedImage5", args{"ns/alpine:1.13"}, &requestedImage{ns: "ns/alpine", tag: "1.13"}, false},
+		{"ParseRequestedImage6", args{"ns/alpine@" + testDigest}, &requestedImage{ns: "ns/alpine", digest: testDigest}, false},
+		{"ParseRequestedImage7", args{"private.registry/alpine"}, &requestedImage{registryHost: "private.registry", ns: "alpine", tag: defaultTag, implicitTag: true}, false},
+		{"ParseRequestedImage8", args{"private.registry/ns/alpine"}, &requestedImage{registryHost: "private.registry", ns: "ns/alpine", tag: defaultTag, implicitTag: true}, false},
+		{"ParseRequestedImage9", args{"private.registry/ns/alpine:1.13"}, &requestedImage{registryHost: "private.registry", ns: "ns/alpine", tag: "1.13"}, false},
+		{"ParseRequestedImage10", args{"private.registry/ns/alpine@" + testDigest}, &requestedImage{registryHost: "private.registry", ns: "ns/alpine", digest: testDigest}, false},
+		{"ParseRequestedImage11", args{"private.registry:8443/alpine"}, &requestedImage{registryHost: "private.registry:8443", ns: "alpine", tag: defaultTag, implicitTag: true}, false},
+		{"ParseRequestedImage12", args{"private.registry:8443/ns/alpine"}, &requestedImage{registryHost: "private.registry:8443", ns: "ns/alpine", tag: defaultTag, implicitTag: true}, false},
+		{"ParseRequestedImage13", args{"private.registry:8443/ns/alpine:1.13"}, &requestedImage{registryHost: "private.registry:8443", ns: "ns/alpine", tag: "1.13"}, false},
+		{"ParseRequestedImage14", args{"private.registry:8443/ns/alpine@" + testDigest}, &requestedImage{registryHost: "private.registry:8443", ns: "ns/alpine", digest: testDigest}, false},
+		{"ParseRequestedImage15", args{"alpine:3.10@" + testDigest}, &requestedImage{ns: "library/alpine", tag: "3.10", digest: testDigest}, false},
+		{"ParseRequestedImage16", args{"private.registry:8443/ns/alpine:1.13@" + testDigest}, &requestedImage{registryHost: "private.registry:8443", ns: "ns/alpine", tag: "1.13", digest: testDigest}, false},
+		{"ParseRequestedImage17", args{"localhost/alpine"}, &requestedImage{registryHost: "localhost", ns: "alpine", tag: defaultTag, implicitTag: true}, false},
+		{"ParseRequestedImage18", args{"localhost:5000/ns/alpine:1.13"}, &requestedImage{registryHost: "localhost:5000", ns: "ns/alpine", tag: "1.13"}, false},
+		{"ParseRequestedImage19", args{"docker.io/alpine"}, &requestedImage{ns: "library/alpine", tag: defaultTag, implicitTag: true}, false},
+		{"ParseRequestedImage20", args{"index.docker.io/ns/alpine"}, &requestedImage{ns: "ns/alpine", tag: defaultTag, implicitTag: true}, false},
+		{"ParseRequestedImage21", args{"ns/my_image.v2-x:1.13_rc-1.a"}, &requestedImage{ns: "ns/my_image.v2-x", tag: "1.13_rc-1.a"}, false},
+		{"ParseRequestedImage22", args{"127.0.0.1:5000/alpine@" + testDigest}, &requestedImage{registryHost: "127.0.0.1:5000", ns: "alpine", digest: testDigest}, false},
+		{"ParseRequestedImage23", args{"Alpine"}, nil, true},
+		{"ParseRequestedImage24", args{"ns/alpine:1.13:1.14"}, nil, true},
+		{"ParseRequestedImage25", args{"alpine@sha256:abcdefgh"}, nil, true},
+		{"ParseRequestedImage26", args{"alpine@md5:d41d8cd98f00b204e9800998ecf8427e"}, nil, true},
+		{"ParseRequestedImage27", args{"ns/al$pine"}, nil, true},
+		{"ParseRequestedImage28", args{"ns//alpine"}, nil, true},
+		{"ParseRequestedImage29", args{""}, nil, true},
+		{"ParseRequestedImage30", args{"alpine:" + strings.Repeat("a", 129)}, nil, true},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if got := ParseRequestedImage(tt.args.s); !reflect.DeepEqual(got, tt.want) {
+			got, err := ParseRequestedImage(tt.args.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseRequestedImage() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("ParseRequestedImage() = %v, want %v", got, tt.want)
 			}
 		})
 	}
 }
 
+func Test_requestedImage_Domain(t *testing.T) {
+	tests := []struct {
+		name          string
+		serverAddress string
+		want          string
+	}{
+		{"Domain1", "", "docker.io"},
+		{"Domain2", "https://index.docker.io/v1/", "docker.io"},
+		{"Domain3", "index.docker.io", "docker.io"},
+		{"Domain4", "registry.local:5000", "registry.local:5000"},
+		{"Domain5", "https://registry.local/", "registry.local"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := ParseRegistry(tt.serverAddress).Domain()
+			if got != tt.want {
+				t.Errorf("Domain() = %v, want %v", got, tt.want)
+			}
+
+			// The full names printed by the catalog are pulled as they are.
+			if _, err := ParseRequestedImage(got + "/team/app"); err != nil {
+				t.Errorf("ParseRequestedImage() error = %v", err)
+			}
+		})
+	}
+}
+
 //func Test_requestedImage_BlobUrl(t *testing.T) {
 //	type fields struct {
 //		insecure     bool
@@ -0,0 +1,354 @@
+/*
+Copyright © 2021 myback.space <git@myback.space>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dockerPull
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/docker/distribution/manifest/manifestlist"
+)
+
+// ErrPlatformNotFound is returned when a manifest list has no image for the
+// requested platform.
+type ErrPlatformNotFound struct {
+	Platform  Platform
+	Available []Platform
+}
+
+func (e *ErrPlatformNotFound) Error() string {
+	available := make([]string, 0, len(e.Available))
+	for _, p := range e.Available {
+		available = append(available, p.String())
+	}
+
+	return fmt.Sprintf("no matching manifest for %s in the manifest list entries, available platforms: %s",
+		e.Platform, strings.Join(available, ", "))
+}
+
+// Platform selects an entry of a manifest list.
+type Platform struct {
+	OS           string
+	Architecture string
+	Variant      string
+	// OSVersion is the Windows build the image has to be made for, e.g.
+	// 10.0.17763.
+	OSVersion string
+}
+
+// ParsePlatform parses a platform written containerd style as
+// os[(os.version)]/arch[/variant], e.g. linux/arm64/v8 or
+// windows(10.0.17763)/amd64. The result is normalized.
+func ParsePlatform(s string) (Platform, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) < 2 || len(parts) > 3 {
+		return Platform{}, fmt.Errorf("invalid platform %q, expected os/arch[/variant]", s)
+	}
+
+	p := Platform{OS: parts[0], Architecture: parts[1]}
+	if len(parts) == 3 {
+		p.Variant = parts[2]
+	}
+
+	if i := strings.IndexByte(p.OS, '('); i > -1 {
+		if !strings.HasSuffix(p.OS, ")") {
+			return Platform{}, fmt.Errorf("invalid platform %q, unterminated os.version", s)
+		}
+		p.OSVersion = p.OS[i+1 : len(p.OS)-1]
+		p.OS = p.OS[:i]
+	}
+
+	for _, part := range []string{p.OS, p.Architecture} {
+		if part == "" {
+			return Platform{}, fmt.Errorf("invalid platform %q, expected os/arch[/variant]", s)
+		}
+	}
+	if len(parts) == 3 && p.Variant == "" {
+		return Platform{}, fmt.Errorf("invalid platform %q, expected os/arch[/variant]", s)
+	}
+
+	return p.Normalize(), nil
+}
+
+func (p Platform) String() string {
+	s := p.OS
+	if p.OSVersion != "" {
+		s += "(" + p.OSVersion + ")"
+	}
+	s += "/" + p.Architecture
+	if p.Variant != "" {
+		s += "/" + p.Variant
+	}
+
+	return s
+}
+
+// Normalize brings the platform to the names used in manifest lists:
+// x86_64 is amd64, aarch64 is arm64 and so on. The default variants, v7 of
+// arm and v8 of arm64, are made explicit.
+func (p Platform) Normalize() Platform {
+	p.OS = strings.ToLower(p.OS)
+	if p.OS == "macos" {
+		p.OS = "darwin"
+	}
+
+	p.Architecture = strings.ToLower(p.Architecture)
+	p.Variant = strings.ToLower(p.Variant)
+	switch p.Architecture {
+	case "i386", "i686", "x86":
+		p.Architecture = "386"
+		p.Variant = ""
+	case "x86_64", "x86-64", "amd64":
+		p.Architecture = "amd64"
+		if p.Variant == "v1" {
+			p.Variant = ""
+		}
+	case "aarch64", "arm64":
+		p.Architecture = "arm64"
+		switch p.Variant {
+		case "", "8", "v8":
+			p.Variant = "v8"
+		}
+	case "armhf":
+		p.Architecture = "arm"
+		p.Variant = "v7"
+	case "armel":
+		p.Architecture = "arm"
+		p.Variant = "v6"
+	case "arm":
+		switch p.Variant {
+		case "", "7":
+			p.Variant = "v7"
+		case "5", "6", "8":
+			p.Variant = "v" + p.Variant
+		}
+	}
+
+	return p
+}
+
+// Match reports whether the image of the manifest list entry spec runs on
+// the platform.
+func (p Platform) Match(spec manifestlist.PlatformSpec) bool {
+	return p.score(spec) >= 0
+}
+
+// BestMatch picks the manifest list entry fitting the platform best:
+//
+//   - os and architecture have to be equal after normalization;
+//   - the same variant is preferred, then the nearest older one the CPU
+//     runs as well (arm/v6 on arm/v7), a platform without a variant takes
+//     any;
+//   - with os.version set only images of the same Windows build match, the
+//     exact revision is preferred;
+//   - among equal entries the one without os.features comes first, then
+//     the first one in the list.
+func (p Platform) BestMatch(manifests []manifestlist.ManifestDescriptor) (manifestlist.ManifestDescriptor, bool) {
+	best, bestScore := -1, -1
+	for i, md := range manifests {
+		if score := p.score(md.Platform); score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+
+	if best < 0 {
+		return manifestlist.ManifestDescriptor{}, false
+	}
+
+	return manifests[best], true
+}
+
+// Fallback picks the entry to use when BestMatch finds none. The os has to
+// be the same, then in this order:
+//
+//   - an image of the same architecture with another variant or Windows
+//     build, the nearest variant first;
+//   - an image of an architecture the CPU runs as well, 386 on amd64 and
+//     arm on arm64, the highest variant first.
+//
+// Ties go to the first entry in the list.
+func (p Platform) Fallback(manifests []manifestlist.ManifestDescriptor) (manifestlist.ManifestDescriptor, bool) {
+	p = p.Normalize()
+
+	best, bestScore := -1, -1
+	for i, md := range manifests {
+		have := platformOf(md.Platform).Normalize()
+		if have.OS != p.OS {
+			continue
+		}
+
+		score := -1
+		switch {
+		case have.Architecture == p.Architecture:
+			score = 2000 - variantDistance(p.Variant, have.Variant)
+		case compatibleArchitectures[p.Architecture] == have.Architecture:
+			n, _ := variantNumber(have.Variant)
+			score = 1000 + n
+		}
+
+		if score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+
+	if best < 0 {
+		return manifestlist.ManifestDescriptor{}, false
+	}
+
+	return manifests[best], true
+}
+
+// compatibleArchitectures maps an architecture to the one its CPUs run in
+// compatibility mode.
+var compatibleArchitectures = map[string]string{
+	"amd64": "386",
+	"arm64": "arm",
+}
+
+func variantDistance(a, b string) int {
+	if a == b {
+		return 0
+	}
+
+	x, xok := variantNumber(a)
+	y, yok := variantNumber(b)
+	if !xok || !yok {
+		return 100
+	}
+	if x > y {
+		return x - y
+	}
+
+	return y - x
+}
+
+// score rates how well spec fits p, a negative score means it does not.
+func (p Platform) score(spec manifestlist.PlatformSpec) int {
+	p = p.Normalize()
+	have := platformOf(spec).Normalize()
+	if p.OS != have.OS || p.Architecture != have.Architecture {
+		return -1
+	}
+
+	variant := variantScore(p.Variant, have.Variant)
+	if variant < 0 {
+		return -1
+	}
+
+	osVersion := 0
+	if p.OSVersion != "" {
+		switch {
+		case spec.OSVersion == p.OSVersion:
+			osVersion = 2
+		case windowsBuild(spec.OSVersion) == windowsBuild(p.OSVersion):
+			osVersion = 1
+		default:
+			return -1
+		}
+	}
+
+	features := 0
+	if len(spec.OSFeatures) == 0 {
+		features = 1
+	}
+
+	return variant*100 + osVersion*10 + features
+}
+
+// variantScore rates the variant have of an image on a CPU of variant want,
+// 0 to 20 with 20 for the same one, or -1 when the image does not run.
+func variantScore(want, have string) int {
+	if want == have {
+		return 20
+	}
+	if want == "" {
+		return 10
+	}
+
+	w, wok := variantNumber(want)
+	h, hok := variantNumber(have)
+	if have == "" {
+		h, hok = 1, true
+	}
+	if !wok || !hok || h > w {
+		return -1
+	}
+
+	if score := 20 - (w - h); score > 0 {
+		return score
+	}
+
+	return 0
+}
+
+// variantNumber parses variants like v7.
+func variantNumber(v string) (int, bool) {
+	if !strings.HasPrefix(v, "v") {
+		return 0, false
+	}
+
+	n, err := strconv.Atoi(v[1:])
+
+	return n, err == nil
+}
+
+// windowsBuild cuts 10.0.17763.1234 down to the build 10.0.17763.
+func windowsBuild(v string) string {
+	parts := strings.SplitN(v, ".", 4)
+	if len(parts) > 3 {
+		parts = parts[:3]
+	}
+
+	return strings.Join(parts, ".")
+}
+
+func platformOf(spec manifestlist.PlatformSpec) Platform {
+	return Platform{
+		OS:           spec.OS,
+		Architecture: spec.Architecture,
+		Variant:      spec.Variant,
+		OSVersion:    spec.OSVersion,
+	}
+}
+
+// availableEntries drops the entries of a manifest list which are not
+// images. Build attestations are listed as unknown/unknown.
+func availableEntries(manifests []manifestlist.ManifestDescriptor) []manifestlist.ManifestDescriptor {
+	var out []manifestlist.ManifestDescriptor
+	for _, md := range manifests {
+		if md.Platform.OS != "unknown" {
+			out = append(out, md)
+		}
+	}
+
+	return out
+}
+
+// availablePlatforms lists the image platforms of a manifest list.
+func availablePlatforms(manifests []manifestlist.ManifestDescriptor) []Platform {
+	var out []Platform
+	for _, md := range availableEntries(manifests) {
+		out = append(out, platformOf(md.Platform))
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].String() < out[j].String()
+	})
+
+	return out
+}
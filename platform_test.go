@@ -0,0 +1,128 @@
+package dockerPull
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/docker/distribution/manifest/manifestlist"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestParsePlatform(t *testing.T) {
+	type args struct {
+		s string
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    Platform
+		wantErr bool
+	}{
+		{"ParsePlatform1", args{"linux/amd64"}, Platform{OS: "linux", Architecture: "amd64"}, false},
+		{"ParsePlatform2", args{"linux/x86_64"}, Platform{OS: "linux", Architecture: "amd64"}, false},
+		{"ParsePlatform3", args{"linux/aarch64"}, Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}, false},
+		{"ParsePlatform4", args{"linux/arm64/v8"}, Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}, false},
+		{"ParsePlatform5", args{"linux/arm"}, Platform{OS: "linux", Architecture: "arm", Variant: "v7"}, false},
+		{"ParsePlatform6", args{"linux/armhf"}, Platform{OS: "linux", Architecture: "arm", Variant: "v7"}, false},
+		{"ParsePlatform7", args{"linux/arm/6"}, Platform{OS: "linux", Architecture: "arm", Variant: "v6"}, false},
+		{"ParsePlatform8", args{"Windows(10.0.17763)/AMD64"}, Platform{OS: "windows", Architecture: "amd64", OSVersion: "10.0.17763"}, false},
+		{"ParsePlatform9", args{"linux"}, Platform{}, true},
+		{"ParsePlatform10", args{"linux/arm/v7/x"}, Platform{}, true},
+		{"ParsePlatform11", args{"linux/arm/"}, Platform{}, true},
+		{"ParsePlatform12", args{"windows(10.0/amd64"}, Platform{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParsePlatform(tt.args.s)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParsePlatform() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParsePlatform() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func entry(n int, spec manifestlist.PlatformSpec) manifestlist.ManifestDescriptor {
+	md := manifestlist.ManifestDescriptor{Platform: spec}
+	md.Digest = digest.FromBytes([]byte{byte(n)})
+	return md
+}
+
+func TestPlatform_BestMatch(t *testing.T) {
+	manifests := []manifestlist.ManifestDescriptor{
+		entry(0, manifestlist.PlatformSpec{OS: "linux", Architecture: "amd64"}),
+		entry(1, manifestlist.PlatformSpec{OS: "linux", Architecture: "arm", Variant: "v6"}),
+		entry(2, manifestlist.PlatformSpec{OS: "linux", Architecture: "arm", Variant: "v7"}),
+		entry(3, manifestlist.PlatformSpec{OS: "linux", Architecture: "arm64", Variant: "v8"}),
+		entry(4, manifestlist.PlatformSpec{OS: "windows", Architecture: "amd64", OSVersion: "10.0.17763.1577"}),
+		entry(5, manifestlist.PlatformSpec{OS: "windows", Architecture: "amd64", OSVersion: "10.0.20348.1"}),
+		entry(6, manifestlist.PlatformSpec{OS: "unknown", Architecture: "unknown"}),
+	}
+	tests := []struct {
+		name   string
+		p      Platform
+		want   int
+		wantOk bool
+	}{
+		{"BestMatch1", Platform{OS: "linux", Architecture: "x86_64"}, 0, true},
+		{"BestMatch2", Platform{OS: "linux", Architecture: "arm", Variant: "v7"}, 2, true},
+		{"BestMatch3", Platform{OS: "linux", Architecture: "arm", Variant: "v6"}, 1, true},
+		{"BestMatch4", Platform{OS: "linux", Architecture: "arm", Variant: "v8"}, 2, true},
+		{"BestMatch5", Platform{OS: "linux", Architecture: "arm", Variant: "v5"}, 0, false},
+		{"BestMatch6", Platform{OS: "linux", Architecture: "aarch64"}, 3, true},
+		{"BestMatch7", Platform{OS: "windows", Architecture: "amd64"}, 4, true},
+		{"BestMatch8", Platform{OS: "windows", Architecture: "amd64", OSVersion: "10.0.20348"}, 5, true},
+		{"BestMatch9", Platform{OS: "windows", Architecture: "amd64", OSVersion: "10.0.19041"}, 0, false},
+		{"BestMatch10", Platform{OS: "linux", Architecture: "s390x"}, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.p.BestMatch(manifests)
+			if ok != tt.wantOk {
+				t.Errorf("BestMatch() ok = %v, want %v", ok, tt.wantOk)
+				return
+			}
+			if ok && got.Digest != manifests[tt.want].Digest {
+				t.Errorf("BestMatch() = %v, want %v", got.Platform, manifests[tt.want].Platform)
+			}
+		})
+	}
+}
+
+func TestPlatform_Fallback(t *testing.T) {
+	manifests := []manifestlist.ManifestDescriptor{
+		entry(0, manifestlist.PlatformSpec{OS: "linux", Architecture: "386"}),
+		entry(1, manifestlist.PlatformSpec{OS: "linux", Architecture: "arm", Variant: "v6"}),
+		entry(2, manifestlist.PlatformSpec{OS: "linux", Architecture: "arm", Variant: "v7"}),
+		entry(3, manifestlist.PlatformSpec{OS: "windows", Architecture: "amd64", OSVersion: "10.0.17763.1577"}),
+		entry(4, manifestlist.PlatformSpec{OS: "unknown", Architecture: "unknown"}),
+	}
+	tests := []struct {
+		name   string
+		p      Platform
+		want   int
+		wantOk bool
+	}{
+		{"Fallback1", Platform{OS: "linux", Architecture: "amd64"}, 0, true},
+		{"Fallback2", Platform{OS: "linux", Architecture: "arm", Variant: "v5"}, 1, true},
+		{"Fallback3", Platform{OS: "linux", Architecture: "arm64"}, 2, true},
+		{"Fallback4", Platform{OS: "windows", Architecture: "amd64", OSVersion: "10.0.20348"}, 3, true},
+		{"Fallback5", Platform{OS: "linux", Architecture: "s390x"}, 0, false},
+		{"Fallback6", Platform{OS: "darwin", Architecture: "amd64"}, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.p.Fallback(manifests)
+			if ok != tt.wantOk {
+				t.Errorf("Fallback() ok = %v, want %v", ok, tt.wantOk)
+				return
+			}
+			if ok && got.Digest != manifests[tt.want].Digest {
+				t.Errorf("Fallback() = %v, want %v", got.Platform, manifests[tt.want].Platform)
+			}
+		})
+	}
+}
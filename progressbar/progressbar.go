@@ -18,9 +18,14 @@ package progressbar
 
 import (
 	"fmt"
+	"io"
 	"math"
 	"os"
+	"strings"
+	"sync"
 	"sync/atomic"
+
+	"github.com/moby/term"
 )
 
 type ProgressBar struct {
@@ -29,6 +34,9 @@ type ProgressBar struct {
 	cur           uint64
 	contentLength uint64
 	description   string
+	pool          *Pool
+	line          int
+	status        string
 }
 
 func (pb *ProgressBar) ContentLength(l int64) {
@@ -41,10 +49,19 @@ func (pb *ProgressBar) SetDescription(s string) {
 }
 
 func (pb *ProgressBar) Close() {
+	if pb.pool != nil {
+		return
+	}
+
 	fmt.Fprintln(os.Stdout)
 }
 
 func (pb *ProgressBar) Flush() {
+	if pb.pool != nil {
+		pb.pool.flush(pb)
+		return
+	}
+
 	desc := pb.description
 	for i := 0; i < int(pb.printLenLine); i++ {
 		desc += " "
@@ -82,13 +99,84 @@ func (pb *ProgressBar) Write(p []byte) (n int, err error) {
 	n = len(p)
 	atomic.AddUint64(&pb.cur, uint64(n))
 
-	l, err := fmt.Fprintf(os.Stdout, "\r%s[%-50s] %7s/%7s",
+	line := fmt.Sprintf("%s[%-50s] %7s/%7s",
 		pb.description, pb.fill(), humanView(pb.cur), humanView(pb.contentLength))
+
+	if pb.pool != nil {
+		if pb.pool.tty {
+			pb.pool.redraw(pb.line, line)
+		}
+		return
+	}
+
+	l, err := fmt.Fprintf(os.Stdout, "\r%s", line)
 	atomic.SwapInt32(&pb.printLenLine, int32(l))
 
 	return
 }
 
+// Pool keeps one line per progress bar, like `docker pull` does, and redraws
+// a line in place whenever its bar is updated. When the output is not a
+// terminal, e.g. a pipe or a CI log, every new status of a bar is printed on
+// a line of its own instead and the progress is left out. It is safe for
+// concurrent use.
+type Pool struct {
+	mu    sync.Mutex
+	out   io.Writer
+	tty   bool
+	width int8
+	bars  map[string]*ProgressBar
+	lines int
+}
+
+// Bar returns the progress bar registered under id, appending a new line
+// for it on the first call.
+func (p *Pool) Bar(id string) *ProgressBar {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if pb, ok := p.bars[id]; ok {
+		return pb
+	}
+
+	pb := &ProgressBar{
+		width: p.width,
+		pool:  p,
+		line:  p.lines,
+	}
+	p.bars[id] = pb
+	p.lines++
+	if p.tty {
+		fmt.Fprintln(p.out)
+	}
+
+	return pb
+}
+
+func (p *Pool) flush(pb *ProgressBar) {
+	if p.tty {
+		p.redraw(pb.line, pb.description)
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	status := strings.TrimSpace(pb.description)
+	if status != pb.status {
+		pb.status = status
+		fmt.Fprintln(p.out, status)
+	}
+}
+
+func (p *Pool) redraw(line int, s string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	diff := p.lines - line
+	fmt.Fprintf(p.out, "\x1b[%dA\r\x1b[2K%s\r\x1b[%dB", diff, s, diff)
+}
+
 func humanView(num uint64) string {
 	if num == 0 {
 		return "0B"
@@ -111,3 +199,12 @@ func NewProgressBar(width int8) *ProgressBar {
 		width: width,
 	}
 }
+
+func NewPool(width int8) *Pool {
+	return &Pool{
+		out:   os.Stdout,
+		tty:   term.IsTerminal(os.Stdout.Fd()),
+		width: width,
+		bars:  map[string]*ProgressBar{},
+	}
+}
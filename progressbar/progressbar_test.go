@@ -0,0 +1,45 @@
+package progressbar
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPool(t *testing.T) {
+	tests := []struct {
+		name string
+		tty  bool
+		want string
+	}{
+		{"Pool1", false, "a: Waiting\nb: Waiting\na: Pull complete\n"},
+		{"Pool2", true, "\n\n" +
+			"\x1b[2A\r\x1b[2Ka: Waiting \r\x1b[2B" +
+			"\x1b[1A\r\x1b[2Kb: Waiting \r\x1b[1B" +
+			"\x1b[1A\r\x1b[2Kb: Waiting \r\x1b[1B" +
+			"\x1b[2A\r\x1b[2Ka: [=>                                                ]    5.0B/ 100.0B\r\x1b[2B" +
+			"\x1b[2A\r\x1b[2Ka: Pull complete \r\x1b[2B"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			p := &Pool{out: &out, tty: tt.tty, width: 50, bars: map[string]*ProgressBar{}}
+
+			a, b := p.Bar("a"), p.Bar("b")
+			a.SetDescription("a: Waiting ")
+			a.Flush()
+			b.SetDescription("b: Waiting ")
+			b.Flush()
+			b.Flush()
+
+			a.SetDescription("a: ")
+			a.ContentLength(100)
+			a.Write(make([]byte, 5))
+			a.SetDescription("a: Pull complete ")
+			a.Flush()
+
+			if got := out.String(); got != tt.want {
+				t.Errorf("Pool output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -17,20 +17,26 @@ limitations under the License.
 package dockerPull
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/docker/distribution"
+	"github.com/docker/distribution/manifest/manifestlist"
 	"github.com/docker/docker/image"
 	imageV1 "github.com/docker/docker/image/v1"
 	"github.com/docker/docker/layer"
 	"github.com/docker/docker/pkg/system"
+	"github.com/myback/go-docker-pull/cache"
+	"github.com/myback/go-docker-pull/config"
+	"github.com/myback/go-docker-pull/progressbar"
 	"github.com/opencontainers/go-digest"
 )
 
@@ -40,6 +46,14 @@ const (
 	legacyConfigFileName       = "json"
 	legacyVersionFileName      = "VERSION"
 	legacyRepositoriesFileName = "repositories"
+
+	defaultMaxConcurrentDownloads = 3
+)
+
+// Output formats of RegistryClient.Pull.
+const (
+	FormatDocker = "docker"
+	FormatOCI    = "oci"
 )
 
 type RegistryClient struct {
@@ -47,7 +61,75 @@ type RegistryClient struct {
 	OS       string
 	Login    string
 	Password string
+	// Insecure makes every registry insecure, see InsecureRegistries.
 	Insecure bool
+	// InsecureRegistries are host[:port] or CIDR entries of registries
+	// whose certificates are not verified and which are asked over plain
+	// HTTP when HTTPS fails, like the insecure-registries of dockerd.
+	// Registries on the loopback interface get the HTTP fallback always.
+	InsecureRegistries []string
+	// MaxConcurrentDownloads limits the number of layers fetched in
+	// parallel, defaults to 3 like dockerd does.
+	MaxConcurrentDownloads int
+	// Format selects the layout Pull writes: FormatDocker (default) for
+	// docker-save or FormatOCI for the OCI image layout.
+	Format string
+	// Platforms are pulled from a manifest list instead of the one of Arch
+	// and OS, AllPlatforms pulls every image of the list. Several platforms
+	// go into one OCI layout or into a docker-save archive each.
+	Platforms    []Platform
+	AllPlatforms bool
+	// AllowPlatformFallback takes the nearest compatible image, see
+	// Platform.Fallback, when a manifest list has none for the platform.
+	// ErrPlatformNotFound is returned otherwise.
+	AllowPlatformFallback bool
+	// Config is the Docker client config credentials are read from when
+	// Login is not set.
+	Config *config.ConfigFile
+	// Retry is the policy of repeating failed requests, DefaultRetryPolicy
+	// when zero. Attempts below one take the default number of attempts.
+	Retry RetryPolicy
+	// Mirrors are tried in order before Docker Hub for its images, e.g. a
+	// pull-through cache like https://mirror.gcr.io.
+	Mirrors []string
+	// Cache is the blob cache shared by pulls, layers are downloaded every
+	// time when nil.
+	Cache *cache.Store
+	// SaveCache keeps the temporary directory of a cancelled pull, so the
+	// next run resumes the downloads. It is removed otherwise.
+	SaveCache bool
+
+	// CACert is a PEM bundle of CA certificates trusted in addition to the
+	// system ones.
+	CACert string
+	// Cert and Key are the client certificate presented to registries
+	// requiring mutual TLS.
+	Cert, Key string
+	// InsecureSkipTLSVerify accepts any registry certificate.
+	InsecureSkipTLSVerify bool
+	// Proxy is the proxy of all registries except the NoProxy ones, see
+	// ParseProxy. HTTP_PROXY, HTTPS_PROXY and NO_PROXY of the environment
+	// are used when empty.
+	Proxy   string
+	NoProxy string
+	// Proxies override the proxy of a registry host[:port] or host, e.g.
+	// to reach only some of them through a proxy. DirectProxy connects
+	// the registry without one. The override of a registry applies to its
+	// token server and blob redirects as well, unless they have their own.
+	Proxies map[string]string
+	// MountFrom are repositories of the destination registry, e.g.
+	// library/alpine, a pushed blob is mounted from before it is uploaded.
+	MountFrom []string
+	// ChunkSize uploads blobs in chunks of the given number of bytes, see
+	// Client.ChunkSize.
+	ChunkSize int64
+
+	// CertsDirs are searched for <host[:port]>/ directories of per registry
+	// certificates, DefaultCertsDirs when nil.
+	CertsDirs []string
+
+	auth       *authCache
+	transports map[string]*hostTransport
 }
 
 type manifestItem struct {
@@ -58,72 +140,281 @@ type manifestItem struct {
 	LayerSources map[layer.DiffID]distribution.Descriptor `json:",omitempty"`
 }
 
-func (rc *RegistryClient) Pull(imageReq *requestedImage) error {
-	if rc.Insecure {
-		imageReq.InsecureRegistry()
+type layerJob struct {
+	diffId    digest.Digest
+	layerDesc distribution.Descriptor
+	v1Img     image.V1Image
+}
+
+// NewClient returns a Client for the image. Clients of one RegistryClient
+// share bearer tokens, so pulling several images from a registry does not
+// authenticate again for every one of them. Login and Password take
+// precedence, otherwise credentials of the registry are looked up in Config.
+func (rc *RegistryClient) NewClient(imageReq *requestedImage) (*Client, error) {
+	if rc.auth == nil {
+		rc.auth = newAuthCache()
 	}
 
-	fmt.Printf("%s: Pulling from %s\n", imageReq.tag, imageReq.ns)
-	imageReq.insecure = rc.Insecure
-	fetcher := &Client{
-		Client: &http.Client{},
-		Image:  imageReq,
+	c := &Client{
+		Client:    rc.httpClient(imageReq.Endpoint().Host),
+		Image:     imageReq,
+		auth:      rc.auth,
+		Retry:     rc.Retry,
+		Cache:     rc.Cache,
+		ChunkSize: rc.ChunkSize,
+		login:     rc.Login,
+		password:  rc.Password,
+	}
+	if c.Retry == (RetryPolicy{}) {
+		c.Retry = DefaultRetryPolicy
+	} else if c.Retry.Attempts <= 0 {
+		c.Retry.Attempts = DefaultRetryPolicy.Attempts
 	}
-	fetcher.SetCredentials(rc.Login, rc.Password)
 
-	list, err := fetcher.GetManifestList()
-	if err != nil {
-		if err != ErrEmptyManifestList {
-			return err
+	for _, m := range rc.Mirrors {
+		mirror, err := ParseMirror(m)
+		if err != nil {
+			return nil, err
 		}
+		c.Mirrors = append(c.Mirrors, mirror)
 	}
 
-	imageOS := rc.OS
-	imageManifestTag := imageReq.tag
-	for _, md := range list.Manifests {
-		if md.Platform.Architecture == rc.Arch && md.Platform.OS == rc.OS {
-			imageOS = md.Platform.OS
-			imageManifestTag = md.Digest.String()
-			break
+	if rc.Login == "" && rc.Config != nil {
+		auth, err := rc.Config.GetAuthConfig(imageReq.ServerAddress())
+		if err != nil {
+			return nil, err
 		}
+
+		c.login = auth.Username
+		c.password = auth.Password
+		c.identityToken = auth.IdentityToken
 	}
 
-	imageManifest, contentDigest, err := fetcher.GetManifest(imageManifestTag)
+	return c, nil
+}
+
+func (rc *RegistryClient) Pull(imageReq *requestedImage) error {
+	return rc.PullContext(context.Background(), imageReq)
+}
+
+// PullContext is Pull bound to ctx. Cancelling ctx stops all downloads and
+// removes the partly written image unless SaveCache is set.
+func (rc *RegistryClient) PullContext(ctx context.Context, imageReq *requestedImage) (err error) {
+	if imageReq.digest != "" {
+		if err := imageReq.digest.Validate(); err != nil {
+			return fmt.Errorf("invalid digest %q: %s", imageReq.digest, err)
+		}
+	}
+
+	fmt.Printf("%s: Pulling from %s\n", imageReq.Reference(), imageReq.ns)
+	fetcher, err := rc.NewClient(imageReq)
 	if err != nil {
 		return err
 	}
+	fetcher.Progress = progressbar.NewPool(50)
 
-	imageManifestFilename := imageManifest.Config.Digest.Hex() + ".json"
-
-	tmpDir, err := imageReq.TempDirCreate()
+	imageManifest, manifestDesc, err := fetcher.GetManifestContext(ctx, imageReq.Reference())
 	if err != nil {
 		return err
 	}
 
-	resp, err := fetcher.GetBlob(imageManifest.Config.Digest, "", 0)
+	images, err := rc.selectImages(ctx, fetcher, imageManifest, manifestDesc)
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
 
-	imageRepoBytes, err := ioutil.ReadAll(resp.Body)
+	var dirs []string
+	defer func() {
+		if err != nil && ctx.Err() != nil && !rc.SaveCache {
+			for _, dir := range dirs {
+				os.RemoveAll(dir)
+			}
+		}
+	}()
+
+	imageReq.platforms = nil
+	switch rc.Format {
+	case FormatOCI:
+		tmpDir, err := imageReq.TempDirCreate()
+		if err != nil {
+			return err
+		}
+		dirs = append(dirs, tmpDir)
+
+		if err := rc.saveOCILayout(ctx, fetcher, tmpDir, images); err != nil {
+			return err
+		}
+	default:
+		for _, img := range images {
+			req := imageReq
+			if len(images) > 1 {
+				req = imageReq.forPlatform(img.platform)
+				imageReq.platforms = append(imageReq.platforms, req)
+			}
+
+			tmpDir, err := req.TempDirCreate()
+			if err != nil {
+				return err
+			}
+			dirs = append(dirs, tmpDir)
+
+			if err := rc.saveDockerArchive(ctx, fetcher, tmpDir, img); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(fetcher.Mirrors) > 0 && imageReq.IsDockerHub() {
+		for _, s := range fetcher.ServedBlobs() {
+			fmt.Printf("%s: served by %s\n", s.Digest.Hex()[:12], s.Endpoint)
+		}
+	}
+
+	if len(images) > 1 {
+		for _, img := range images {
+			fmt.Printf("%s: %s\n", img.platform, img.desc.Digest)
+		}
+	} else {
+		manifestDesc = images[0].desc
+	}
+	fmt.Println("Digest:", manifestDesc.Digest)
+
+	return nil
+}
+
+// platformImage is an image manifest picked from the pulled reference.
+type platformImage struct {
+	platform Platform
+	// spec is the platform of the manifest list entry, nil for an image
+	// pulled by its own manifest.
+	spec     *manifestlist.PlatformSpec
+	desc     distribution.Descriptor
+	manifest distribution.Manifest
+}
+
+// ParseMirror parses a registry mirror given as a URL or a host[:port],
+// which is taken as https://host[:port].
+func ParseMirror(s string) (*url.URL, error) {
+	if !strings.Contains(s, "://") {
+		s = "https://" + s
+	}
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid registry mirror %q: %s", s, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+		return nil, fmt.Errorf("invalid registry mirror %q, expected [http[s]://]host[:port][/path]", s)
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+
+	return u, nil
+}
+
+// platform is the platform of Arch and OS.
+func (rc *RegistryClient) platform() Platform {
+	return Platform{OS: rc.OS, Architecture: rc.Arch}.Normalize()
+}
+
+// selectImages resolves the manifest of the pulled reference into the image
+// manifests to save. An image manifest is taken as is, from a manifest list
+// the best match of every platform of Platforms or all images with
+// AllPlatforms are taken, otherwise the best match of Arch and OS.
+func (rc *RegistryClient) selectImages(ctx context.Context, fetcher *Client, m distribution.Manifest, desc distribution.Descriptor) ([]platformImage, error) {
+	list, ok := m.(*manifestlist.DeserializedManifestList)
+	if !ok {
+		return []platformImage{{
+			platform: rc.platform(),
+			desc:     desc,
+			manifest: m,
+		}}, nil
+	}
+
+	if rc.AllPlatforms {
+		return rc.listImages(ctx, fetcher, availableEntries(list.Manifests))
+	}
+
+	wanted := rc.Platforms
+	if len(wanted) == 0 {
+		wanted = []Platform{rc.platform()}
+	}
+
+	var entries []manifestlist.ManifestDescriptor
+	seen := map[digest.Digest]bool{}
+	for _, p := range wanted {
+		md, ok := p.BestMatch(list.Manifests)
+		if !ok && rc.AllowPlatformFallback {
+			if md, ok = p.Fallback(list.Manifests); ok {
+				fmt.Printf("%s: no image for %s, falling back to %s\n", fetcher.Image.Reference(), p, platformOf(md.Platform))
+			}
+		}
+		if !ok {
+			return nil, &ErrPlatformNotFound{
+				Platform:  p,
+				Available: availablePlatforms(list.Manifests),
+			}
+		}
+
+		if !seen[md.Digest] {
+			seen[md.Digest] = true
+			entries = append(entries, md)
+		}
+	}
+
+	return rc.listImages(ctx, fetcher, entries)
+}
+
+// listImages fetches the image manifests of the manifest list entries.
+func (rc *RegistryClient) listImages(ctx context.Context, fetcher *Client, entries []manifestlist.ManifestDescriptor) ([]platformImage, error) {
+	if len(entries) == 0 {
+		return nil, ErrEmptyManifestList
+	}
+
+	images := make([]platformImage, 0, len(entries))
+	for _, md := range entries {
+		imageManifest, manifestDesc, err := fetcher.getManifest(ctx, md.Digest.String(), imageManifestMediaTypes)
+		if err != nil {
+			return nil, err
+		}
+		spec := md.Platform
+		images = append(images, platformImage{
+			platform: platformOf(spec),
+			spec:     &spec,
+			desc:     manifestDesc,
+			manifest: imageManifest,
+		})
+	}
+
+	return images, nil
+}
+
+// saveDockerArchive writes the legacy docker-save layout (manifest.json,
+// repositories and a <v1id>/layer.tar directory per layer) into dir.
+func (rc *RegistryClient) saveDockerArchive(ctx context.Context, fetcher *Client, dir string, img platformImage) error {
+	configDesc, layers, err := imageManifestParts(img.manifest)
 	if err != nil {
 		return err
 	}
 
-	imageManifestFilepath := filepath.Join(tmpDir, imageManifestFilename)
-	f, err := os.Create(imageManifestFilepath)
+	imageRepoBytes, err := fetcher.GetBlobBytesContext(ctx, configDesc)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
 
-	f.Write(imageRepoBytes)
+	imageReq := fetcher.Image
+	imageManifestFilename := configDesc.Digest.Hex() + ".json"
+	imageManifestFilepath := filepath.Join(dir, imageManifestFilename)
+	if err := ioutil.WriteFile(imageManifestFilepath, imageRepoBytes, 0644); err != nil {
+		return err
+	}
 
 	imageConfig := image.Image{}
 	if err := json.Unmarshal(imageRepoBytes, &imageConfig); err != nil {
 		return err
 	}
+	if imageConfig.RootFS == nil || len(imageConfig.RootFS.DiffIDs) != len(layers) {
+		return fmt.Errorf("%s: the layers of the manifest do not match the image config", configDesc.Digest)
+	}
 
 	imageRepo := imageReq.ns
 	if strings.HasPrefix(imageReq.ns, officialRepoName+"/") && imageReq.registryHost == "" {
@@ -134,13 +425,18 @@ func (rc *RegistryClient) Pull(imageReq *requestedImage) error {
 		return err
 	}
 
+	// An image pulled by digest has no tag unless one is given, like an
+	// untagged image in docker save.
 	var manifest []manifestItem
 	newImageManifest := manifestItem{
-		Config:   imageManifestFilename,
-		RepoTags: []string{imageRepo + ":" + imageReq.tag},
+		Config: imageManifestFilename,
+	}
+	if imageReq.tag != "" {
+		newImageManifest.RepoTags = []string{imageRepo + ":" + imageReq.tag}
 	}
 
 	var parentId digest.Digest
+	jobs := make([]layerJob, 0, len(imageConfig.RootFS.DiffIDs))
 	for i, diffId := range imageConfig.RootFS.DiffIDs {
 		v1Img := image.V1Image{
 			Created: time.Unix(0, 0).UTC(),
@@ -161,27 +457,125 @@ func (rc *RegistryClient) Pull(imageReq *requestedImage) error {
 		}
 		parentId = v1ID
 		v1Img.ID = v1ID.Hex()
-		v1Img.OS = imageOS
+		v1Img.OS = img.platform.OS
 		newImageManifest.Layers = append(newImageManifest.Layers, filepath.Join(v1Img.ID, legacyLayerFileName))
 
-		if err := fetcher.GetLayer(tmpDir, digest.Digest(diffId), imageManifest.Layers[i], v1Img, imageConfig.Created.UTC()); err != nil {
+		jobs = append(jobs, layerJob{
+			diffId:    digest.Digest(diffId),
+			layerDesc: layers[i],
+			v1Img:     v1Img,
+		})
+	}
+
+	created := imageConfig.Created.UTC()
+	fetch := func(ctx context.Context, job layerJob) error {
+		return fetcher.GetLayerContext(ctx, dir, job.diffId, job.layerDesc, job.v1Img, created)
+	}
+
+	// A blob repeated in the image, like the empty layer some builders emit
+	// for ENV or LABEL, is fetched once. Its later entries copy the
+	// extracted layer.tar into their own directory afterwards.
+	unique, repeated := splitRepeatedLayers(jobs)
+	if err := rc.fetchLayers(ctx, fetcher, unique, fetch); err != nil {
+		return err
+	}
+	for _, job := range repeated {
+		if err := fetch(ctx, job); err != nil {
 			return err
 		}
 	}
 
 	manifest = append(manifest, newImageManifest)
 
-	if err := SaveToJson(filepath.Join(tmpDir, manifestFileName), manifest); err != nil {
+	if err := SaveToJson(filepath.Join(dir, manifestFileName), manifest); err != nil {
 		return err
 	}
 
-	if err := SaveToJson(filepath.Join(tmpDir, legacyRepositoriesFileName), map[string]map[string]string{
-		imageRepo: {imageReq.tag: parentId.Hex()},
-	}); err != nil {
-		return err
+	files := []string{manifestFileName}
+	if imageReq.tag != "" {
+		if err := SaveToJson(filepath.Join(dir, legacyRepositoriesFileName), map[string]map[string]string{
+			imageRepo: {imageReq.tag: parentId.Hex()},
+		}); err != nil {
+			return err
+		}
+		files = append(files, legacyRepositoriesFileName)
+	}
+
+	return chtimes(dir, files, time.Unix(0, 0))
+}
+
+// splitRepeatedLayers separates the first job of every layer blob from the
+// jobs repeating an earlier blob, both keep the order of jobs.
+func splitRepeatedLayers(jobs []layerJob) (unique, repeated []layerJob) {
+	seen := map[digest.Digest]bool{}
+	for _, job := range jobs {
+		if seen[job.layerDesc.Digest] {
+			repeated = append(repeated, job)
+			continue
+		}
+		seen[job.layerDesc.Digest] = true
+		unique = append(unique, job)
+	}
+
+	return unique, repeated
+}
+
+// fetchLayers calls fetch for every job using at most MaxConcurrentDownloads
+// workers. Jobs are queued in DiffID order, so lower layers start first and
+// each layer keeps its own progress line. The first failure cancels the
+// remaining jobs and is returned.
+func (rc *RegistryClient) fetchLayers(ctx context.Context, fetcher *Client, jobs []layerJob, fetch func(context.Context, layerJob) error) error {
+	for _, job := range jobs {
+		bar := fetcher.progressBar(job.layerDesc.Digest.Hex()[:12])
+		bar.SetDescription(fmt.Sprintf("%s: %s ", job.layerDesc.Digest.Hex()[:12], "Waiting"))
+		bar.Flush()
+	}
+
+	workers := rc.MaxConcurrentDownloads
+	if workers <= 0 {
+		workers = defaultMaxConcurrentDownloads
 	}
 
-	fmt.Println("Digest:", contentDigest)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	queue := make(chan int)
+
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for i := range queue {
+				if err := fetch(ctx, jobs[i]); err != nil {
+					errOnce.Do(func() {
+						firstErr = err
+						cancel()
+					})
+				}
+			}
+		}()
+	}
+
+loop:
+	for i := range jobs {
+		select {
+		case queue <- i:
+		case <-ctx.Done():
+			break loop
+		}
+	}
+	close(queue)
+	wg.Wait()
+
+	if firstErr != nil {
+		return firstErr
+	}
 
-	return chtimes(tmpDir, []string{manifestFileName, legacyRepositoriesFileName}, time.Unix(0, 0))
+	return ctx.Err()
 }
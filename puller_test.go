@@ -0,0 +1,393 @@
+package dockerPull
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/manifest/manifestlist"
+	"github.com/myback/go-docker-pull/archive"
+	"github.com/opencontainers/go-digest"
+)
+
+func Test_splitRepeatedLayers(t *testing.T) {
+	job := func(blob string, diffId string) layerJob {
+		return layerJob{
+			diffId:    digest.FromString(diffId),
+			layerDesc: distribution.Descriptor{Digest: digest.FromString(blob)},
+		}
+	}
+	tests := []struct {
+		name         string
+		jobs         []layerJob
+		wantUnique   []layerJob
+		wantRepeated []layerJob
+	}{
+		{"splitRepeatedLayers1", []layerJob{job("a", "1"), job("b", "2")}, []layerJob{job("a", "1"), job("b", "2")}, nil},
+		{"splitRepeatedLayers2", []layerJob{job("a", "1"), job("e", "0"), job("b", "2"), job("e", "0"), job("e", "0")},
+			[]layerJob{job("a", "1"), job("e", "0"), job("b", "2")}, []layerJob{job("e", "0"), job("e", "0")}},
+		{"splitRepeatedLayers3", nil, nil, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotUnique, gotRepeated := splitRepeatedLayers(tt.jobs)
+			if !reflect.DeepEqual(gotUnique, tt.wantUnique) {
+				t.Errorf("splitRepeatedLayers() unique = %v, want %v", gotUnique, tt.wantUnique)
+			}
+			if !reflect.DeepEqual(gotRepeated, tt.wantRepeated) {
+				t.Errorf("splitRepeatedLayers() repeated = %v, want %v", gotRepeated, tt.wantRepeated)
+			}
+		})
+	}
+}
+
+func TestParseMirror(t *testing.T) {
+	type args struct {
+		s string
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    string
+		wantErr bool
+	}{
+		{"ParseMirror1", args{"mirror.gcr.io"}, "https://mirror.gcr.io", false},
+		{"ParseMirror2", args{"http://localhost:5000/"}, "http://localhost:5000", false},
+		{"ParseMirror3", args{"https://mirror.local/docker-hub/"}, "https://mirror.local/docker-hub", false},
+		{"ParseMirror4", args{"ftp://mirror.local"}, "", true},
+		{"ParseMirror5", args{"https://"}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseMirror(tt.args.s)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseMirror() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil && got.String() != tt.want {
+				t.Errorf("ParseMirror() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegistryClient_saveDockerArchiveLayerCount(t *testing.T) {
+	reg := newTestRegistry()
+	defer reg.Close()
+
+	layer := []byte("layer")
+	diffID := digest.FromString("layer.tar")
+	tests := []struct {
+		name    string
+		diffIDs []digest.Digest
+		layers  [][]byte
+	}{
+		{"saveDockerArchiveLayerCount1", []digest.Digest{diffID, diffID}, [][]byte{layer}},
+		{"saveDockerArchiveLayerCount2", []digest.Digest{diffID}, [][]byte{layer, layer}},
+		{"saveDockerArchiveLayerCount3", nil, [][]byte{layer}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, _ := json.Marshal(map[string]interface{}{"rootfs": map[string]interface{}{"type": "layers", "diff_ids": tt.diffIDs}})
+			desc := addImage(t, reg, "test", config, tt.layers...)
+			m, _, err := distribution.UnmarshalManifest(desc.MediaType, reg.manifests["test"][desc.Digest.String()])
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rc := &RegistryClient{}
+			err = rc.saveDockerArchive(context.Background(), reg.client("test", 0), t.TempDir(), platformImage{desc: desc, manifest: m})
+			if err == nil || !strings.Contains(err.Error(), "do not match") {
+				t.Errorf("saveDockerArchive() error = %v, want the layers mismatch", err)
+			}
+		})
+	}
+}
+
+func TestRegistryClient_PullContextCancel(t *testing.T) {
+	reg := newTestRegistry()
+	defer reg.Close()
+
+	fast, slow := []byte("fast layer"), []byte("slow layer")
+	gzFast, gzSlow := gzipBytes(t, fast), gzipBytes(t, slow)
+	config, _ := json.Marshal(map[string]interface{}{"rootfs": map[string]interface{}{
+		"type": "layers", "diff_ids": []digest.Digest{digest.FromBytes(fast), digest.FromBytes(slow)},
+	}})
+	desc := addImage(t, reg, "test", config, gzFast, gzSlow)
+	reg.addManifest("test", "latest", desc.MediaType, reg.manifests["test"][desc.Digest.String()])
+
+	tests := []struct {
+		name      string
+		saveCache bool
+	}{
+		{"PullContextCancel1", false},
+		{"PullContextCancel2", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			// The slow layer stalls half way through until the pull is
+			// cancelled.
+			slowPath := "/v2/test/blobs/" + digest.FromBytes(gzSlow).String()
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != slowPath || r.Header.Get("Authorization") == "" {
+					reg.serve(w, r)
+					return
+				}
+
+				w.Header().Set("Content-Length", strconv.Itoa(len(gzSlow)))
+				w.Write(gzSlow[:len(gzSlow)/2])
+				w.(http.Flusher).Flush()
+				cancel()
+				<-r.Context().Done()
+			}))
+			defer srv.Close()
+
+			wd, err := os.Getwd()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chdir(t.TempDir()); err != nil {
+				t.Fatal(err)
+			}
+			defer os.Chdir(wd)
+
+			u, _ := url.Parse(srv.URL)
+			imageReq, err := ParseRequestedImage(u.Host + "/test:latest")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rc := &RegistryClient{CertsDirs: []string{}, Retry: RetryPolicy{Attempts: 1}, SaveCache: tt.saveCache}
+			if err := rc.PullContext(ctx, imageReq); !errors.Is(err, context.Canceled) {
+				t.Errorf("PullContext() error = %v, want %v", err, context.Canceled)
+			}
+
+			dirs, _ := filepath.Glob("*.tmp")
+			if tt.saveCache && len(dirs) != 1 || !tt.saveCache && len(dirs) != 0 {
+				t.Errorf("PullContext() left %v, SaveCache %v", dirs, tt.saveCache)
+			}
+		})
+	}
+}
+
+func TestRegistryClient_fetchLayersError(t *testing.T) {
+	jobs := make([]layerJob, 5)
+	for i := range jobs {
+		jobs[i].layerDesc.Digest = digest.FromString(strconv.Itoa(i))
+	}
+
+	errFetch := errors.New("fetch failed")
+	rc := &RegistryClient{MaxConcurrentDownloads: len(jobs)}
+	var mu sync.Mutex
+	var cancelled int
+	err := rc.fetchLayers(context.Background(), &Client{}, jobs, func(ctx context.Context, job layerJob) error {
+		if job.layerDesc.Digest == jobs[len(jobs)-1].layerDesc.Digest {
+			return errFetch
+		}
+
+		<-ctx.Done()
+		mu.Lock()
+		cancelled++
+		mu.Unlock()
+
+		return ctx.Err()
+	})
+	if err != errFetch {
+		t.Errorf("fetchLayers() error = %v, want %v", err, errFetch)
+	}
+	if cancelled != len(jobs)-1 {
+		t.Errorf("fetchLayers() cancelled %d jobs, want %d", cancelled, len(jobs)-1)
+	}
+}
+
+func TestRegistryClient_PullRepeatedLayer(t *testing.T) {
+	reg := newTestRegistry()
+	defer reg.Close()
+
+	base, app := []byte("base layer"), []byte("app layer")
+	gzBase, gzApp := gzipBytes(t, base), gzipBytes(t, app)
+	config, _ := json.Marshal(map[string]interface{}{"rootfs": map[string]interface{}{
+		"type": "layers", "diff_ids": []digest.Digest{digest.FromBytes(base), digest.FromBytes(app), digest.FromBytes(base)},
+	}})
+	desc := addImage(t, reg, "test", config, gzBase, gzApp, gzBase)
+	reg.addManifest("test", "latest", desc.MediaType, reg.manifests["test"][desc.Digest.String()])
+
+	var mu sync.Mutex
+	gets := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/blobs/") && r.Header.Get("Authorization") != "" {
+			mu.Lock()
+			gets[r.URL.Path]++
+			mu.Unlock()
+		}
+		reg.serve(w, r)
+	}))
+	defer srv.Close()
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	u, _ := url.Parse(srv.URL)
+	imageReq, err := ParseRequestedImage(u.Host + "/test:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rc := &RegistryClient{CertsDirs: []string{}, Retry: RetryPolicy{Attempts: 1}}
+	if err := rc.PullContext(context.Background(), imageReq); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, b := range [][]byte{gzBase, gzApp} {
+		if n := gets["/v2/test/blobs/"+digest.FromBytes(b).String()]; n != 1 {
+			t.Errorf("PullContext() downloaded %s %d times, want once", digest.FromBytes(b), n)
+		}
+	}
+
+	layers, _ := filepath.Glob(filepath.Join(imageReq.tempDir, "*", legacyLayerFileName))
+	if len(layers) != 3 {
+		t.Errorf("PullContext() wrote layers %v, want 3", layers)
+	}
+}
+
+func TestRegistryClient_PullContextInvalidDigest(t *testing.T) {
+	imageReq := &requestedImage{registryHost: "registry.test", ns: "test", digest: "sha256:abcdefgh"}
+	rc := &RegistryClient{CertsDirs: []string{}}
+	if err := rc.PullContext(context.Background(), imageReq); err == nil || !strings.Contains(err.Error(), "invalid digest") {
+		t.Errorf("PullContext() error = %v, want an invalid digest", err)
+	}
+}
+
+func TestRegistryClient_PullPlatforms(t *testing.T) {
+	reg := newTestRegistry()
+	defer reg.Close()
+
+	base := []byte("base layer")
+	gzBase := gzipBytes(t, base)
+	var entries []manifestlist.ManifestDescriptor
+	for _, arch := range []string{"amd64", "arm64"} {
+		app := []byte(arch + " layer")
+		config, _ := json.Marshal(map[string]interface{}{
+			"architecture": arch,
+			"os":           "linux",
+			"rootfs":       map[string]interface{}{"type": "layers", "diff_ids": []digest.Digest{digest.FromBytes(base), digest.FromBytes(app)}},
+		})
+		desc := addImage(t, reg, "test", config, gzBase, gzipBytes(t, app))
+		entries = append(entries, manifestlist.ManifestDescriptor{Descriptor: desc, Platform: manifestlist.PlatformSpec{OS: "linux", Architecture: arch}})
+	}
+
+	// A build attestation is listed as unknown/unknown and never pulled.
+	attestation := addImage(t, reg, "test", []byte(`{}`))
+	entries = append(entries, manifestlist.ManifestDescriptor{Descriptor: attestation, Platform: manifestlist.PlatformSpec{OS: "unknown", Architecture: "unknown"}})
+
+	list, err := manifestlist.FromDescriptors(entries)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mediaType, payload, _ := list.Payload()
+	reg.addManifest("test", "1.0", mediaType, payload)
+
+	var mu sync.Mutex
+	gets := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "" {
+			mu.Lock()
+			gets[r.URL.Path]++
+			mu.Unlock()
+		}
+		reg.serve(w, r)
+	}))
+	defer srv.Close()
+
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	u, _ := url.Parse(srv.URL)
+	imageReq, err := ParseRequestedImage(u.Host + "/test:1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rc := &RegistryClient{CertsDirs: []string{}, Retry: RetryPolicy{Attempts: 1}, AllPlatforms: true}
+	if err := rc.PullContext(context.Background(), imageReq); err != nil {
+		t.Fatal(err)
+	}
+
+	outputs := imageReq.Outputs()
+	var names []string
+	for _, out := range outputs {
+		if err := archive.TarContext(context.Background(), out.TempDir(), out.OutputImageName()); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, out.OutputImageName())
+	}
+	if want := []string{"test_1.0_linux_amd64.tar", "test_1.0_linux_arm64.tar"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("Outputs() = %v, want %v", names, want)
+	}
+
+	for _, out := range outputs {
+		f, err := os.Open(out.OutputImageName())
+		if err != nil {
+			t.Fatal(err)
+		}
+		dir := t.TempDir()
+		err = archive.Untar(dir, f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var manifest []manifestItem
+		b, err := ioutil.ReadFile(filepath.Join(dir, manifestFileName))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(b, &manifest); err != nil {
+			t.Fatal(err)
+		}
+		if len(manifest) != 1 || !reflect.DeepEqual(manifest[0].RepoTags, []string{"test:1.0"}) || len(manifest[0].Layers) != 2 {
+			t.Errorf("%s: manifest.json = %+v, want one image tagged test:1.0 with 2 layers", out.OutputImageName(), manifest)
+			continue
+		}
+
+		layer, err := ioutil.ReadFile(filepath.Join(dir, manifest[0].Layers[0]))
+		if err != nil || !bytes.Equal(layer, base) {
+			t.Errorf("%s: base layer = %q, %v, want %q", out.OutputImageName(), layer, err, base)
+		}
+	}
+
+	if n := gets["/v2/test/blobs/"+digest.FromBytes(gzBase).String()]; n != 1 {
+		t.Errorf("PullContext() downloaded the shared layer %d times, want once", n)
+	}
+	if n := gets["/v2/test/manifests/"+attestation.Digest.String()]; n != 0 {
+		t.Errorf("PullContext() requested the attestation manifest %d times, want none", n)
+	}
+}
@@ -0,0 +1,486 @@
+/*
+Copyright © 2021 myback.space <git@myback.space>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dockerPull
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/manifest/manifestlist"
+	"github.com/docker/distribution/manifest/schema2"
+	"github.com/docker/docker/image"
+	"github.com/docker/docker/layer"
+	"github.com/myback/go-docker-pull/archive"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Push uploads the image at src, a docker-save archive or an OCI image
+// layout as written by Pull, and tags it as imageReq. src is a tar file or
+// a directory holding the extracted layout.
+//
+// The layer.tar files of a docker-save archive are compressed with gzip
+// and a Docker schema2 manifest is built for the image, the archive has to
+// hold exactly one. Blobs and manifests of an OCI layout are pushed as they
+// are, several manifests in its index.json are tagged as one index.
+func (rc *RegistryClient) Push(src string, imageReq *requestedImage) error {
+	return rc.PushContext(context.Background(), src, imageReq)
+}
+
+// PushContext is Push bound to ctx, cancelling ctx aborts the uploads.
+func (rc *RegistryClient) PushContext(ctx context.Context, src string, imageReq *requestedImage) error {
+	if imageReq.Digest() != "" {
+		return fmt.Errorf("%s: an image is pushed to a tag, not a digest", imageReq)
+	}
+
+	fi, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+
+	workDir, err := ioutil.TempDir("", "docker-push")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(workDir)
+
+	dir := src
+	if !fi.IsDir() {
+		dir = filepath.Join(workDir, "image")
+		if err := untarFile(dir, src); err != nil {
+			return err
+		}
+	}
+
+	pusher, err := rc.NewClient(imageReq)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("The push refers to repository [%s]\n", imageReq.Name())
+
+	var (
+		mediaType string
+		payload   []byte
+	)
+	if _, err := os.Stat(filepath.Join(dir, ociIndexFileName)); err == nil {
+		mediaType, payload, err = rc.pushOCILayout(ctx, pusher, dir)
+	} else {
+		mediaType, payload, err = rc.pushDockerArchive(ctx, pusher, dir, workDir)
+	}
+	if err != nil {
+		return err
+	}
+
+	dgst, err := pusher.PutManifestContext(ctx, imageReq.Tag(), mediaType, payload)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s: digest: %s size: %d\n", imageReq.Tag(), dgst, len(payload))
+
+	return nil
+}
+
+func untarFile(dst, src string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := os.MkdirAll(dst, os.ModePerm); err != nil {
+		return err
+	}
+
+	return archive.Untar(dst, f)
+}
+
+// pushDockerArchive pushes the layers and the config of the docker-save
+// archive in dir and returns the schema2 manifest of the image. Compressed
+// layers are written to workDir.
+func (rc *RegistryClient) pushDockerArchive(ctx context.Context, pusher *Client, dir, workDir string) (string, []byte, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, manifestFileName))
+	if err != nil {
+		return "", nil, err
+	}
+
+	var items []manifestItem
+	if err := json.Unmarshal(b, &items); err != nil {
+		return "", nil, fmt.Errorf("%s: %s", manifestFileName, err)
+	}
+	if len(items) != 1 {
+		return "", nil, fmt.Errorf("%s: the archive holds %d images, one can be pushed", manifestFileName, len(items))
+	}
+	item := items[0]
+
+	configPath, err := layoutPath(dir, item.Config)
+	if err != nil {
+		return "", nil, err
+	}
+	configBytes, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var img image.Image
+	if err := json.Unmarshal(configBytes, &img); err != nil {
+		return "", nil, fmt.Errorf("%s: %s", item.Config, err)
+	}
+	if img.RootFS == nil || len(img.RootFS.DiffIDs) != len(item.Layers) {
+		return "", nil, fmt.Errorf("%s: the layers do not match the image config", manifestFileName)
+	}
+
+	// A layer repeated in the image is compressed and pushed once.
+	compressed := map[digest.Digest]distribution.Descriptor{}
+	layers := make([]distribution.Descriptor, 0, len(item.Layers))
+	for i, name := range item.Layers {
+		diffID := digest.Digest(img.RootFS.DiffIDs[i])
+
+		// A foreign layer is referenced by its URLs and not pushed.
+		if desc, ok := item.LayerSources[layer.DiffID(diffID)]; ok {
+			layers = append(layers, desc)
+			continue
+		}
+
+		if desc, ok := compressed[diffID]; ok {
+			layers = append(layers, desc)
+			continue
+		}
+
+		layerPath, err := layoutPath(dir, name)
+		if err != nil {
+			return "", nil, err
+		}
+
+		desc, blobPath, err := compressLayer(layerPath, workDir, diffID)
+		if err != nil {
+			return "", nil, err
+		}
+
+		if err := rc.pushBlobFile(ctx, pusher, desc, blobPath); err != nil {
+			return "", nil, err
+		}
+		compressed[diffID] = desc
+		layers = append(layers, desc)
+	}
+
+	configDesc := distribution.Descriptor{
+		MediaType: schema2.MediaTypeImageConfig,
+		Size:      int64(len(configBytes)),
+		Digest:    digest.FromBytes(configBytes),
+	}
+	if err := rc.pushBlob(ctx, pusher, configDesc, bytes.NewReader(configBytes)); err != nil {
+		return "", nil, err
+	}
+
+	m, err := schema2.FromStruct(schema2.Manifest{
+		Versioned: schema2.SchemaVersion,
+		Config:    configDesc,
+		Layers:    layers,
+	})
+	if err != nil {
+		return "", nil, err
+	}
+
+	return m.Payload()
+}
+
+// compressLayer returns the gzip compressed blob of the layer.tar at src
+// written into workDir and verified against diffID. A layer compressed
+// already is returned as it is once its content is verified.
+func compressLayer(src, workDir string, diffID digest.Digest) (distribution.Descriptor, string, error) {
+	f, err := os.Open(src)
+	if err != nil {
+		return distribution.Descriptor{}, "", err
+	}
+	defer f.Close()
+
+	desc := distribution.Descriptor{MediaType: schema2.MediaTypeLayer}
+
+	magic := make([]byte, 2)
+	if n, _ := io.ReadFull(f, magic); n == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return distribution.Descriptor{}, "", err
+		}
+
+		// The blob is hashed while its content is checked against diffID.
+		blobDigester := digest.Canonical.Digester()
+		blob := io.TeeReader(f, blobDigester.Hash())
+		gz, err := gzip.NewReader(blob)
+		if err != nil {
+			return distribution.Descriptor{}, "", err
+		}
+		diffDigester := digest.Canonical.Digester()
+		if _, err := io.Copy(diffDigester.Hash(), gz); err != nil {
+			return distribution.Descriptor{}, "", err
+		}
+		// Data trailing the gzip stream is part of the blob as well.
+		if _, err := io.Copy(ioutil.Discard, blob); err != nil {
+			return distribution.Descriptor{}, "", err
+		}
+
+		if actual := diffDigester.Digest(); actual != diffID {
+			return distribution.Descriptor{}, "", &ErrDigestMismatch{Blob: diffID, Expected: diffID, Actual: actual}
+		}
+
+		fi, err := f.Stat()
+		if err != nil {
+			return distribution.Descriptor{}, "", err
+		}
+		desc.Size = fi.Size()
+		desc.Digest = blobDigester.Digest()
+
+		return desc, src, nil
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return distribution.Descriptor{}, "", err
+	}
+
+	out, err := ioutil.TempFile(workDir, "layer-*.tar.gz")
+	if err != nil {
+		return distribution.Descriptor{}, "", err
+	}
+	defer out.Close()
+
+	blobDigester := digest.Canonical.Digester()
+	diffDigester := digest.Canonical.Digester()
+	gz := gzip.NewWriter(io.MultiWriter(out, blobDigester.Hash()))
+	if _, err := io.Copy(gz, io.TeeReader(f, diffDigester.Hash())); err != nil {
+		return distribution.Descriptor{}, "", err
+	}
+	if err := gz.Close(); err != nil {
+		return distribution.Descriptor{}, "", err
+	}
+
+	if actual := diffDigester.Digest(); actual != diffID {
+		return distribution.Descriptor{}, "", &ErrDigestMismatch{Blob: diffID, Expected: diffID, Actual: actual}
+	}
+
+	fi, err := out.Stat()
+	if err != nil {
+		return distribution.Descriptor{}, "", err
+	}
+	desc.Size = fi.Size()
+	desc.Digest = blobDigester.Digest()
+
+	return desc, out.Name(), nil
+}
+
+// pushOCILayout pushes the blobs and manifests of the OCI layout in dir and
+// returns the manifest to tag: the only one of index.json or an index of
+// all of them.
+func (rc *RegistryClient) pushOCILayout(ctx context.Context, pusher *Client, dir string) (string, []byte, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, ociIndexFileName))
+	if err != nil {
+		return "", nil, err
+	}
+
+	// The OCI index and the Docker manifest list share the JSON layout.
+	var index manifestlist.ManifestList
+	if err := json.Unmarshal(b, &index); err != nil {
+		return "", nil, fmt.Errorf("%s: %s", ociIndexFileName, err)
+	}
+	if len(index.Manifests) == 0 {
+		return "", nil, fmt.Errorf("%s: %s", ociIndexFileName, ErrEmptyManifestList)
+	}
+
+	pushed := map[digest.Digest]bool{}
+	if len(index.Manifests) == 1 {
+		return rc.pushOCIManifest(ctx, pusher, dir, index.Manifests[0].Descriptor, pushed, false)
+	}
+
+	descs := make([]manifestlist.ManifestDescriptor, 0, len(index.Manifests))
+	for _, desc := range index.Manifests {
+		if _, _, err := rc.pushOCIManifest(ctx, pusher, dir, desc.Descriptor, pushed, true); err != nil {
+			return "", nil, err
+		}
+
+		// The tag of the layout is replaced by the pushed one.
+		delete(desc.Annotations, v1.AnnotationRefName)
+		if len(desc.Annotations) == 0 {
+			desc.Annotations = nil
+		}
+		descs = append(descs, desc)
+	}
+
+	list, err := manifestlist.FromDescriptors(descs)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return list.Payload()
+}
+
+// pushOCIManifest pushes the blobs of the manifest desc stored in the
+// layout, the manifests of an index first, and returns the manifest
+// unchanged. byDigest puts the manifest itself as well. pushed keeps the
+// blobs shared by manifests from being pushed again.
+func (rc *RegistryClient) pushOCIManifest(ctx context.Context, pusher *Client, dir string, desc distribution.Descriptor, pushed map[digest.Digest]bool, byDigest bool) (string, []byte, error) {
+	payload, err := readOCIBlob(dir, desc.Digest)
+	if err != nil {
+		return "", nil, err
+	}
+
+	m, _, err := unmarshalManifest(desc.MediaType, payload)
+	if err != nil {
+		return "", nil, fmt.Errorf("manifest %s: %s", desc.Digest, err)
+	}
+	mediaType, _, err := m.Payload()
+	if err != nil {
+		return "", nil, err
+	}
+
+	if list, ok := m.(*manifestlist.DeserializedManifestList); ok {
+		for _, child := range list.Manifests {
+			if _, _, err := rc.pushOCIManifest(ctx, pusher, dir, child.Descriptor, pushed, true); err != nil {
+				return "", nil, err
+			}
+		}
+	} else {
+		configDesc, layers, err := imageManifestParts(m)
+		if err != nil {
+			return "", nil, err
+		}
+
+		for _, blob := range append([]distribution.Descriptor{configDesc}, layers...) {
+			if pushed[blob.Digest] {
+				continue
+			}
+			if err := blob.Digest.Validate(); err != nil {
+				return "", nil, err
+			}
+
+			blobPath := ociBlobPath(dir, blob.Digest)
+
+			// A non-distributable layer missing in the layout is
+			// referenced by its URLs.
+			if _, err := os.Stat(blobPath); os.IsNotExist(err) && len(blob.URLs) > 0 {
+				continue
+			}
+
+			if err := rc.pushBlobFile(ctx, pusher, blob, blobPath); err != nil {
+				return "", nil, err
+			}
+			pushed[blob.Digest] = true
+		}
+	}
+
+	if byDigest {
+		if _, err := pusher.PutManifestContext(ctx, desc.Digest.String(), mediaType, payload); err != nil {
+			return "", nil, err
+		}
+	}
+
+	return mediaType, payload, nil
+}
+
+// readOCIBlob reads a blob of the layout verifying it against dgst.
+func readOCIBlob(dir string, dgst digest.Digest) ([]byte, error) {
+	if err := dgst.Validate(); err != nil {
+		return nil, err
+	}
+
+	b, err := ioutil.ReadFile(ociBlobPath(dir, dgst))
+	if err != nil {
+		return nil, err
+	}
+
+	if actual := dgst.Algorithm().FromBytes(b); actual != dgst {
+		return nil, &ErrDigestMismatch{Blob: dgst, Expected: dgst, Actual: actual}
+	}
+
+	return b, nil
+}
+
+// layoutPath joins a path named in a layout file to dir refusing the ones
+// leaving dir.
+func layoutPath(dir, name string) (string, error) {
+	p := filepath.Join(dir, filepath.FromSlash(name))
+	if rel, err := filepath.Rel(dir, p); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is outside of the image", name)
+	}
+
+	return p, nil
+}
+
+func (rc *RegistryClient) pushBlobFile(ctx context.Context, pusher *Client, desc distribution.Descriptor, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return rc.pushBlob(ctx, pusher, desc, f)
+}
+
+// pushBlob uploads the blob read from r unless the repository has it
+// already or it can be mounted from one of MountFrom.
+func (rc *RegistryClient) pushBlob(ctx context.Context, pusher *Client, desc distribution.Descriptor, r io.Reader) error {
+	id := desc.Digest.Hex()[:12]
+
+	exists, err := pusher.BlobExistsContext(ctx, desc.Digest)
+	if err != nil {
+		return err
+	}
+	if exists {
+		fmt.Printf("%s: Layer already exists\n", id)
+		return nil
+	}
+
+	// The upload session the registry opens for a failed mount is the one
+	// the blob is uploaded through, the session of an earlier mount is
+	// cancelled when another repository is tried.
+	var location *url.URL
+	for _, from := range rc.MountFrom {
+		if from == pusher.Image.ns {
+			continue
+		}
+
+		if location != nil {
+			pusher.cancelUpload(ctx, location)
+		}
+
+		// A failed mount is no reason to stop, the blob is uploaded.
+		var mounted bool
+		location, mounted, err = pusher.mountBlob(ctx, desc.Digest, from)
+		if err != nil && ctx.Err() != nil {
+			return err
+		}
+		if mounted {
+			fmt.Printf("%s: Mounted from %s\n", id, from)
+			return nil
+		}
+	}
+
+	if err := pusher.uploadBlob(ctx, desc, r, location); err != nil {
+		return fmt.Errorf("blob %s: %w", desc.Digest, err)
+	}
+	fmt.Printf("%s: Pushed\n", id)
+
+	return nil
+}
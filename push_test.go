@@ -0,0 +1,291 @@
+package dockerPull
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/manifest/manifestlist"
+	"github.com/docker/distribution/manifest/ocischema"
+	"github.com/docker/distribution/manifest/schema2"
+	"github.com/myback/go-docker-pull/archive"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// writeDockerArchive writes a docker-save layout of an image with the
+// layers into dir, the last layer is repeated.
+func writeDockerArchive(t *testing.T, dir string, layers ...[]byte) {
+	layers = append(layers, layers[len(layers)-1])
+
+	var item manifestItem
+	var diffIDs []string
+	for i, l := range layers {
+		name := filepath.Join(string(rune('a'+i)), legacyLayerFileName)
+		if err := os.MkdirAll(filepath.Join(dir, filepath.Dir(name)), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, name), l, 0644); err != nil {
+			t.Fatal(err)
+		}
+		item.Layers = append(item.Layers, name)
+		diffIDs = append(diffIDs, digest.FromBytes(l).String())
+	}
+
+	config, _ := json.Marshal(map[string]interface{}{
+		"architecture": "amd64",
+		"os":           "linux",
+		"rootfs":       map[string]interface{}{"type": "layers", "diff_ids": diffIDs},
+	})
+	item.Config = digest.FromBytes(config).Hex() + ".json"
+	if err := ioutil.WriteFile(filepath.Join(dir, item.Config), config, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := SaveToJson(filepath.Join(dir, manifestFileName), []manifestItem{item}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeOCILayout writes an OCI layout with an image manifest per layer
+// into dir and returns the manifest digests.
+func writeOCILayout(t *testing.T, dir string, layers ...[]byte) []digest.Digest {
+	config := []byte(`{"architecture":"amd64","os":"linux"}`)
+	if err := writeOCIBlob(dir, digest.FromBytes(config), config); err != nil {
+		t.Fatal(err)
+	}
+
+	var digests []digest.Digest
+	index := v1.Index{}
+	index.SchemaVersion = 2
+	for _, l := range layers {
+		if err := writeOCIBlob(dir, digest.FromBytes(l), l); err != nil {
+			t.Fatal(err)
+		}
+
+		m, err := ocischema.FromStruct(ocischema.Manifest{
+			Versioned: ocischema.SchemaVersion,
+			Config:    distribution.Descriptor{MediaType: v1.MediaTypeImageConfig, Digest: digest.FromBytes(config), Size: int64(len(config))},
+			Layers:    []distribution.Descriptor{{MediaType: v1.MediaTypeImageLayerGzip, Digest: digest.FromBytes(l), Size: int64(len(l))}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		mediaType, payload, _ := m.Payload()
+		if err := writeOCIBlob(dir, digest.FromBytes(payload), payload); err != nil {
+			t.Fatal(err)
+		}
+
+		digests = append(digests, digest.FromBytes(payload))
+		index.Manifests = append(index.Manifests, v1.Descriptor{
+			MediaType:   mediaType,
+			Digest:      digest.FromBytes(payload),
+			Size:        int64(len(payload)),
+			Annotations: map[string]string{v1.AnnotationRefName: "old"},
+		})
+	}
+
+	if err := SaveToJson(filepath.Join(dir, ociIndexFileName), index); err != nil {
+		t.Fatal(err)
+	}
+
+	return digests
+}
+
+func TestRegistryClient_Push(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "push-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	layer1, layer2 := []byte("layer one"), []byte("layer two")
+
+	dockerDir := filepath.Join(tmp, "docker")
+	writeDockerArchive(t, dockerDir, layer1, layer2)
+	dockerTar := filepath.Join(tmp, "docker.tar")
+	if err := archive.Tar(dockerDir, dockerTar); err != nil {
+		t.Fatal(err)
+	}
+
+	ociSingle := filepath.Join(tmp, "oci-single")
+	single := writeOCILayout(t, ociSingle, layer1)
+	ociMulti := filepath.Join(tmp, "oci-multi")
+	multi := writeOCILayout(t, ociMulti, layer1, layer2)
+
+	tests := []struct {
+		name      string
+		src       string
+		chunkSize int64
+		mediaType string
+		children  []digest.Digest
+	}{
+		{"Push1", dockerDir, 0, schema2.MediaTypeManifest, nil},
+		{"Push2", dockerTar, 4, schema2.MediaTypeManifest, nil},
+		{"Push3", ociSingle, 0, v1.MediaTypeImageManifest, nil},
+		{"Push4", ociMulti, 0, v1.MediaTypeImageIndex, multi},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := newTestRegistry()
+			defer reg.Close()
+
+			u, _ := url.Parse(reg.URL)
+			rc := &RegistryClient{CertsDirs: []string{}, Retry: RetryPolicy{Attempts: 1}, ChunkSize: tt.chunkSize}
+			req := &requestedImage{registryHost: u.Host, ns: "team/app", tag: "1.0"}
+			if err := rc.PushContext(context.Background(), tt.src, req); err != nil {
+				t.Fatal(err)
+			}
+
+			payload := reg.manifests["team/app"]["1.0"]
+			if reg.types["team/app"]["1.0"] != tt.mediaType {
+				t.Fatalf("manifest media type %q, want %q", reg.types["team/app"]["1.0"], tt.mediaType)
+			}
+
+			m, _, err := distribution.UnmarshalManifest(tt.mediaType, payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if list, ok := m.(*manifestlist.DeserializedManifestList); ok {
+				if len(list.Manifests) != len(tt.children) {
+					t.Fatalf("index has %d manifests, want %d", len(list.Manifests), len(tt.children))
+				}
+				for i, desc := range list.Manifests {
+					if desc.Digest != tt.children[i] || reg.manifests["team/app"][desc.Digest.String()] == nil {
+						t.Errorf("index entry %d is %s, want pushed %s", i, desc.Digest, tt.children[i])
+					}
+					if desc.Annotations[v1.AnnotationRefName] != "" {
+						t.Errorf("index entry %d keeps the ref name of the layout", i)
+					}
+				}
+				return
+			}
+
+			if tt.mediaType == v1.MediaTypeImageManifest && digest.FromBytes(payload) != single[0] {
+				t.Errorf("manifest %s, want the layout one %s", digest.FromBytes(payload), single[0])
+			}
+
+			configDesc, layers, err := imageManifestParts(m)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := reg.blob("team/app", configDesc.Digest); !ok {
+				t.Errorf("config %s was not pushed", configDesc.Digest)
+			}
+
+			for _, l := range layers {
+				b, ok := reg.blob("team/app", l.Digest)
+				if !ok || int64(len(b)) != l.Size {
+					t.Fatalf("layer %s was not pushed", l.Digest)
+				}
+
+				if tt.mediaType == schema2.MediaTypeManifest {
+					gz, err := gzip.NewReader(bytes.NewReader(b))
+					if err != nil {
+						t.Fatal(err)
+					}
+					if b, err = ioutil.ReadAll(gz); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if !bytes.Equal(b, layer1) && !bytes.Equal(b, layer2) {
+					t.Errorf("layer %s holds %q", l.Digest, b)
+				}
+			}
+			if tt.mediaType == schema2.MediaTypeManifest && (len(layers) != 3 || layers[1].Digest != layers[2].Digest) {
+				t.Errorf("got layers %v, want 3 with the last one repeated", layers)
+			}
+		})
+	}
+}
+
+func TestRegistryClient_PushMountFrom(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "push-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+
+	layer1 := []byte("layer one")
+	writeOCILayout(t, tmp, layer1)
+
+	reg := newTestRegistry()
+	defer reg.Close()
+	reg.addBlob("library/base", layer1)
+
+	u, _ := url.Parse(reg.URL)
+	rc := &RegistryClient{CertsDirs: []string{}, Retry: RetryPolicy{Attempts: 1}, MountFrom: []string{"library/missing", "library/base"}}
+	req := &requestedImage{registryHost: u.Host, ns: "team/app", tag: "1.0"}
+
+	// The second push finds every blob in the repository.
+	for i := 0; i < 2; i++ {
+		if err := rc.PushContext(context.Background(), tmp, req); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, ok := reg.blob("team/app", digest.FromBytes(layer1)); !ok {
+		t.Error("the layer was not mounted")
+	}
+	if reg.mounts != 1 {
+		t.Errorf("got %d mounts, want 1", reg.mounts)
+	}
+}
+
+func Test_compressLayer(t *testing.T) {
+	layer := []byte("layer.tar content")
+	gzLayer := gzipBytes(t, layer)
+	corrupted := append([]byte{}, gzLayer...)
+	corrupted[len(corrupted)-5] ^= 0xff
+
+	tests := []struct {
+		name     string
+		content  []byte
+		diffID   digest.Digest
+		wantBlob []byte
+		wantErr  bool
+	}{
+		{"compressLayer1", layer, digest.FromBytes(layer), nil, false},
+		{"compressLayer2", layer, digest.FromString("other"), nil, true},
+		{"compressLayer3", gzLayer, digest.FromBytes(layer), gzLayer, false},
+		{"compressLayer4", gzLayer, digest.FromString("other"), nil, true},
+		{"compressLayer5", corrupted, digest.FromBytes(layer), nil, true},
+		{"compressLayer6", gzLayer[:len(gzLayer)/2], digest.FromBytes(layer), nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			src := filepath.Join(dir, "layer.tar")
+			if err := ioutil.WriteFile(src, tt.content, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			desc, path, err := compressLayer(src, dir, tt.diffID)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("compressLayer() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			b, err := ioutil.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantBlob != nil && !bytes.Equal(b, tt.wantBlob) {
+				t.Errorf("compressLayer() blob = %q, want %q", b, tt.wantBlob)
+			}
+			if desc.Digest != digest.FromBytes(b) || desc.Size != int64(len(b)) {
+				t.Errorf("compressLayer() = %s %d, want %s %d", desc.Digest, desc.Size, digest.FromBytes(b), len(b))
+			}
+		})
+	}
+}
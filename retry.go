@@ -0,0 +1,178 @@
+/*
+Copyright © 2021 myback.space <git@myback.space>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dockerPull
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetryPolicy controls how requests failed by a dropped connection, a 5xx
+// or 429 status are repeated. The delay starts at Backoff and doubles with
+// every attempt up to MaxBackoff, Jitter randomizes it by the given fraction.
+// A Retry-After header sent with 429 or 503 overrides the delay, it is
+// limited by MaxBackoff as well.
+type RetryPolicy struct {
+	Attempts   int
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	Jitter     float64
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	Attempts:   5,
+	Backoff:    time.Second,
+	MaxBackoff: 30 * time.Second,
+	Jitter:     0.2,
+}
+
+var errContentRange = errors.New("Content-Range does not match the requested offset")
+
+// ErrHTTPStatus is returned when the registry answers with an error status.
+type ErrHTTPStatus struct {
+	StatusCode int
+	Body       []byte
+	RetryAfter time.Duration
+}
+
+func (e *ErrHTTPStatus) Error() string {
+	return fmt.Sprintf("status code [%d]: error: \"%s\"", e.StatusCode, e.Body)
+}
+
+func newErrHTTPStatus(resp *http.Response) *ErrHTTPStatus {
+	b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+
+	return &ErrHTTPStatus{
+		StatusCode: resp.StatusCode,
+		Body:       b,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+}
+
+// parseRetryAfter accepts both forms of the header: delay in seconds or an
+// HTTP date.
+func parseRetryAfter(s string) time.Duration {
+	if s == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(strings.TrimSpace(s)); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if t, err := http.ParseTime(s); err == nil {
+		return time.Until(t)
+	}
+
+	return 0
+}
+
+func (p RetryPolicy) delay(attempt int, err error) time.Duration {
+	var statusErr *ErrHTTPStatus
+	if errors.As(err, &statusErr) && statusErr.RetryAfter > 0 &&
+		(statusErr.StatusCode == http.StatusTooManyRequests || statusErr.StatusCode == http.StatusServiceUnavailable) {
+		if p.MaxBackoff > 0 && statusErr.RetryAfter > p.MaxBackoff {
+			return p.MaxBackoff
+		}
+		return statusErr.RetryAfter
+	}
+
+	d := p.Backoff << uint(attempt)
+	if p.MaxBackoff > 0 && (d > p.MaxBackoff || d <= 0) {
+		d = p.MaxBackoff
+	}
+
+	if p.Jitter > 0 {
+		d += time.Duration((rand.Float64()*2 - 1) * p.Jitter * float64(d))
+	}
+
+	return d
+}
+
+// retry calls fn until it succeeds, fails with an error not worth repeating,
+// the attempts are over or ctx is done. notify, if set, is told about every
+// delay.
+func (c *Client) retry(ctx context.Context, fn func() error, notify func(time.Duration)) error {
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || attempt+1 >= c.Retry.Attempts || !retryable(err) || ctx.Err() != nil {
+			return err
+		}
+
+		d := c.Retry.delay(attempt, err)
+		if notify != nil {
+			notify(d)
+		}
+
+		timer := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+func retryable(err error) bool {
+	var statusErr *ErrHTTPStatus
+	if errors.As(err, &statusErr) {
+		return statusErr.StatusCode == http.StatusRequestTimeout ||
+			statusErr.StatusCode == http.StatusTooManyRequests ||
+			statusErr.StatusCode >= 500
+	}
+
+	// A manifest is requested by the digest it is checked against, the
+	// registry sends the same content on every attempt.
+	var mismatchErr *ErrDigestMismatch
+	if errors.As(err, &mismatchErr) {
+		return !mismatchErr.Manifest
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return true
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+
+	return errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) || errors.Is(err, errContentRange)
+}
+
+// checkContentRange makes sure a 206 answer starts at offset, otherwise the
+// data would be appended at the wrong place.
+func checkContentRange(resp *http.Response, offset int64) error {
+	var start, end int64
+	hdr := resp.Header.Get("Content-Range")
+	if _, err := fmt.Sscanf(hdr, "bytes %d-%d/", &start, &end); err != nil || start != offset {
+		return fmt.Errorf("%w: %q, offset %d", errContentRange, hdr, offset)
+	}
+
+	return nil
+}
@@ -0,0 +1,215 @@
+package dockerPull
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/docker/distribution"
+	"github.com/opencontainers/go-digest"
+)
+
+func Test_checkContentRange(t *testing.T) {
+	type args struct {
+		contentRange string
+		offset       int64
+	}
+	tests := []struct {
+		name    string
+		args    args
+		wantErr bool
+	}{
+		{"checkContentRange1", args{"bytes 100-199/200", 100}, false},
+		{"checkContentRange2", args{"bytes 100-199/*", 100}, false},
+		{"checkContentRange3", args{"bytes 0-199/200", 100}, true},
+		{"checkContentRange4", args{"bytes 150-199/200", 100}, true},
+		{"checkContentRange5", args{"", 100}, true},
+		{"checkContentRange6", args{"bytes */200", 100}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{Header: http.Header{}}
+			resp.Header.Set("Content-Range", tt.args.contentRange)
+			if err := checkContentRange(resp, tt.args.offset); (err != nil) != tt.wantErr {
+				t.Errorf("checkContentRange() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRetryPolicy_delay(t *testing.T) {
+	p := RetryPolicy{Attempts: 5, Backoff: time.Second, MaxBackoff: 30 * time.Second}
+	tests := []struct {
+		name    string
+		attempt int
+		err     error
+		want    time.Duration
+	}{
+		{"delay1", 0, io.ErrUnexpectedEOF, time.Second},
+		{"delay2", 3, io.ErrUnexpectedEOF, 8 * time.Second},
+		{"delay3", 10, io.ErrUnexpectedEOF, 30 * time.Second},
+		{"delay4", 0, &ErrHTTPStatus{StatusCode: http.StatusTooManyRequests, RetryAfter: 5 * time.Second}, 5 * time.Second},
+		{"delay5", 0, &ErrHTTPStatus{StatusCode: http.StatusServiceUnavailable, RetryAfter: 5 * time.Second}, 5 * time.Second},
+		{"delay6", 0, &ErrHTTPStatus{StatusCode: http.StatusInternalServerError, RetryAfter: 5 * time.Second}, time.Second},
+		{"delay7", 0, &ErrHTTPStatus{StatusCode: http.StatusTooManyRequests, RetryAfter: 24 * time.Hour}, 30 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := p.delay(tt.attempt, tt.err); got != tt.want {
+				t.Errorf("delay() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_parseRetryAfter(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  time.Duration
+	}{
+		{"parseRetryAfter1", "", 0},
+		{"parseRetryAfter2", "120", 2 * time.Minute},
+		{"parseRetryAfter3", "soon", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseRetryAfter(tt.value); got != tt.want {
+				t.Errorf("parseRetryAfter() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	date := time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+	if got := parseRetryAfter(date); got <= 0 || got > time.Minute {
+		t.Errorf("parseRetryAfter(%q) = %v, want up to a minute", date, got)
+	}
+}
+
+func TestClient_retry(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		attempts int
+	}{
+		{"retry1", &ErrHTTPStatus{StatusCode: http.StatusInternalServerError}, 3},
+		{"retry2", &ErrHTTPStatus{StatusCode: http.StatusBadGateway}, 3},
+		{"retry3", &ErrHTTPStatus{StatusCode: http.StatusTooManyRequests}, 3},
+		{"retry4", &ErrHTTPStatus{StatusCode: http.StatusRequestTimeout}, 3},
+		{"retry5", &ErrHTTPStatus{StatusCode: http.StatusNotFound}, 1},
+		{"retry6", &ErrHTTPStatus{StatusCode: http.StatusUnauthorized}, 1},
+		{"retry7", &ErrDigestMismatch{}, 3},
+		{"retry8", io.ErrUnexpectedEOF, 3},
+		{"retry9", ErrImageNotFound, 1},
+		{"retry10", &ErrDigestMismatch{Manifest: true}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Client{Retry: RetryPolicy{Attempts: 3, Backoff: time.Millisecond}}
+
+			var calls, notified int
+			err := c.retry(context.Background(), func() error {
+				calls++
+				return tt.err
+			}, func(time.Duration) { notified++ })
+			if err != tt.err {
+				t.Errorf("retry() error = %v, want %v", err, tt.err)
+			}
+			if calls != tt.attempts || notified != tt.attempts-1 {
+				t.Errorf("retry() called fn %d times and notified %d times, want %d attempts", calls, notified, tt.attempts)
+			}
+		})
+	}
+}
+
+func TestClient_downloadBlobResume(t *testing.T) {
+	blob := []byte("0123456789abcdefghijklmnopqrstuvwxyz")
+	dgst := digest.FromBytes(blob)
+
+	tests := []struct {
+		name        string
+		honorRange  bool
+		wantRequest []string
+	}{
+		{"downloadBlobResume1", true, []string{"", "bytes=18-"}},
+		{"downloadBlobResume2", false, []string{"", "bytes=18-"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ranges []string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				ranges = append(ranges, r.Header.Get("Range"))
+				if len(ranges) == 1 {
+					// Drop the connection half way through the blob.
+					w.Header().Set("Content-Length", strconv.Itoa(len(blob)))
+					w.Write(blob[:len(blob)/2])
+					return
+				}
+
+				if tt.honorRange && r.Header.Get("Range") != "" {
+					w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", len(blob)/2, len(blob)-1, len(blob)))
+					w.WriteHeader(http.StatusPartialContent)
+					w.Write(blob[len(blob)/2:])
+					return
+				}
+
+				w.Write(blob)
+			}))
+			defer srv.Close()
+
+			u, _ := url.Parse(srv.URL)
+			c := &Client{
+				Client: (&RegistryClient{CertsDirs: []string{}}).httpClient(""),
+				Image:  &requestedImage{registryHost: u.Host, ns: "test", tag: "latest"},
+				Retry:  RetryPolicy{Attempts: 3, Backoff: time.Millisecond},
+			}
+
+			path := filepath.Join(t.TempDir(), "blob")
+			desc := distribution.Descriptor{Digest: dgst, Size: int64(len(blob))}
+			if err := c.downloadBlob(context.Background(), path, desc, c.progressBar("blob")); err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(ranges, tt.wantRequest) {
+				t.Errorf("downloadBlob() requested ranges %q, want %q", ranges, tt.wantRequest)
+			}
+			if b, _ := ioutil.ReadFile(path); !bytes.Equal(b, blob) {
+				t.Errorf("downloadBlob() = %q, want %q", b, blob)
+			}
+		})
+	}
+}
+
+func TestRegistryClient_NewClientRetry(t *testing.T) {
+	tests := []struct {
+		name  string
+		retry RetryPolicy
+		want  RetryPolicy
+	}{
+		{"NewClientRetry1", RetryPolicy{}, DefaultRetryPolicy},
+		{"NewClientRetry2", RetryPolicy{Attempts: 2}, RetryPolicy{Attempts: 2}},
+		{"NewClientRetry3", RetryPolicy{Backoff: 5 * time.Second, Jitter: 0.5}, RetryPolicy{Attempts: DefaultRetryPolicy.Attempts, Backoff: 5 * time.Second, Jitter: 0.5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rc := &RegistryClient{Retry: tt.retry, CertsDirs: []string{}}
+			c, err := rc.NewClient(&requestedImage{registryHost: "registry.example.com", ns: "test", tag: "latest"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.Retry != tt.want {
+				t.Errorf("NewClient() Retry = %+v, want %+v", c.Retry, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,340 @@
+/*
+Copyright © 2021 myback.space <git@myback.space>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dockerPull
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version is a semantic version parsed from an image tag. Tags commonly
+// drop the patch or minor number or carry a v prefix, so v1.2 is 1.2.0.
+type Version struct {
+	Major, Minor, Patch uint64
+	Prerelease          string
+}
+
+// ParseVersion parses [v]MAJOR[.MINOR[.PATCH]][-PRERELEASE][+BUILD], the
+// build metadata is dropped.
+func ParseVersion(s string) (Version, error) {
+	v, n, err := parseVersion(s, false)
+	if err != nil {
+		return Version{}, err
+	}
+	if n < 0 {
+		return Version{}, fmt.Errorf("invalid version %q", s)
+	}
+
+	return v, nil
+}
+
+// parseVersion returns the number of the given parts as well, wildcards
+// x, X and * end the version when allowed.
+func parseVersion(s string, wildcards bool) (Version, int, error) {
+	var v Version
+	invalid := fmt.Errorf("invalid version %q", s)
+
+	rest := strings.TrimPrefix(strings.TrimPrefix(s, "v"), "V")
+	if i := strings.IndexByte(rest, '+'); i > -1 {
+		rest = rest[:i]
+	}
+	if i := strings.IndexByte(rest, '-'); i > -1 {
+		v.Prerelease = rest[i+1:]
+		rest = rest[:i]
+		if v.Prerelease == "" {
+			return v, 0, invalid
+		}
+		for _, id := range strings.Split(v.Prerelease, ".") {
+			if id == "" || strings.Trim(id, "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ-") != "" {
+				return v, 0, invalid
+			}
+		}
+	}
+
+	parts := strings.Split(rest, ".")
+	if len(parts) > 3 {
+		return v, 0, invalid
+	}
+
+	nums := []*uint64{&v.Major, &v.Minor, &v.Patch}
+	for i, p := range parts {
+		if wildcards && (p == "x" || p == "X" || p == "*") {
+			if v.Prerelease != "" {
+				return v, 0, invalid
+			}
+			return v, i, nil
+		}
+
+		n, err := strconv.ParseUint(p, 10, 64)
+		if err != nil || (len(p) > 1 && p[0] == '0') {
+			return v, 0, invalid
+		}
+		*nums[i] = n
+	}
+
+	return v, len(parts), nil
+}
+
+func (v Version) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if v.Prerelease != "" {
+		s += "-" + v.Prerelease
+	}
+
+	return s
+}
+
+// Compare returns -1, 0 or 1 when v is lower, equal or greater than o in
+// the semantic versioning precedence.
+func (v Version) Compare(o Version) int {
+	for _, d := range [][2]uint64{{v.Major, o.Major}, {v.Minor, o.Minor}, {v.Patch, o.Patch}} {
+		if d[0] != d[1] {
+			if d[0] < d[1] {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	switch {
+	case v.Prerelease == o.Prerelease:
+		return 0
+	case v.Prerelease == "":
+		return 1
+	case o.Prerelease == "":
+		return -1
+	}
+
+	a, b := strings.Split(v.Prerelease, "."), strings.Split(o.Prerelease, ".")
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if c := comparePrereleaseID(a[i], b[i]); c != 0 {
+			return c
+		}
+	}
+
+	switch {
+	case len(a) < len(b):
+		return -1
+	case len(a) > len(b):
+		return 1
+	}
+
+	return 0
+}
+
+// comparePrereleaseID orders numeric identifiers numerically and before
+// alphanumeric ones, which are compared in ASCII order.
+func comparePrereleaseID(a, b string) int {
+	na, errA := strconv.ParseUint(a, 10, 64)
+	nb, errB := strconv.ParseUint(b, 10, 64)
+	switch {
+	case errA == nil && errB == nil:
+		if na == nb {
+			return 0
+		}
+		if na < nb {
+			return -1
+		}
+		return 1
+	case errA == nil:
+		return -1
+	case errB == nil:
+		return 1
+	}
+
+	return strings.Compare(a, b)
+}
+
+// VersionRange is a set of versions like npm and Masterminds/semver write
+// them: comparators separated by spaces must all match, alternatives are
+// separated by ||.
+type VersionRange [][]versionComparator
+
+type versionComparator struct {
+	op string
+	v  Version
+}
+
+// ParseVersionRange parses a range of comparators =, !=, >, >=, <, <= and
+// the shorthands ^1.2 (>=1.2.0 <2.0.0), ~1.2 (>=1.2.0 <1.3.0) and partial
+// or wildcard versions, 1.2 and 1.2.x are both >=1.2.0 <1.3.0.
+func ParseVersionRange(s string) (VersionRange, error) {
+	var r VersionRange
+	for _, alt := range strings.Split(s, "||") {
+		var set []versionComparator
+		fields := strings.Fields(alt)
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("invalid version range %q", s)
+		}
+
+		for i := 0; i < len(fields); i++ {
+			f := fields[i]
+			// An operator may be separated from its version: ">= 1.2".
+			if strings.Trim(f, "=!<>^~") == "" && i+1 < len(fields) {
+				i++
+				f += fields[i]
+			}
+
+			cs, err := parseComparator(f)
+			if err != nil {
+				return nil, fmt.Errorf("invalid version range %q: %s", s, err)
+			}
+			set = append(set, cs...)
+		}
+		r = append(r, set)
+	}
+
+	return r, nil
+}
+
+func parseComparator(s string) ([]versionComparator, error) {
+	op := s[:len(s)-len(strings.TrimLeft(s, "=!<>^~"))]
+	switch op {
+	case "", "=", "!=", ">", ">=", "<", "<=", "^", "~":
+	default:
+		return nil, fmt.Errorf("unknown operator %q", op)
+	}
+
+	v, n, err := parseVersion(s[len(op):], true)
+	if err != nil {
+		return nil, err
+	}
+
+	// upper is the first version outside of a partial version of n parts.
+	upper := func(n int) Version {
+		switch n {
+		case 1:
+			return Version{Major: v.Major + 1}
+		case 2:
+			return Version{Major: v.Major, Minor: v.Minor + 1}
+		}
+		return Version{Major: v.Major, Minor: v.Minor, Patch: v.Patch + 1}
+	}
+
+	switch op {
+	case "^":
+		if n == 0 {
+			return nil, nil
+		}
+		// The left-most non-zero part must not change.
+		switch {
+		case v.Major > 0 || n == 1:
+			n = 1
+		case v.Minor > 0 || n == 2:
+			n = 2
+		default:
+			n = 3
+		}
+		return []versionComparator{{">=", v}, {"<", upper(n)}}, nil
+	case "~":
+		if n == 0 {
+			return nil, nil
+		}
+		if n == 3 {
+			n = 2
+		}
+		return []versionComparator{{">=", v}, {"<", upper(n)}}, nil
+	}
+
+	if n == 3 {
+		if op == "" {
+			op = "="
+		}
+		return []versionComparator{{op, v}}, nil
+	}
+
+	// A partial version stands for all of its versions.
+	switch op {
+	case "", "=":
+		if n == 0 {
+			return nil, nil
+		}
+		return []versionComparator{{">=", v}, {"<", upper(n)}}, nil
+	case "!=":
+		return nil, fmt.Errorf("%q needs a full version", s)
+	case ">":
+		if n == 0 {
+			return []versionComparator{{"<", Version{}}}, nil
+		}
+		return []versionComparator{{">=", upper(n)}}, nil
+	case "<=":
+		if n == 0 {
+			return nil, nil
+		}
+		return []versionComparator{{"<", upper(n)}}, nil
+	}
+
+	// >= and < of a partial version are those of its lowest version.
+	if n == 0 && op == "<" {
+		return []versionComparator{{"<", Version{}}}, nil
+	}
+
+	return []versionComparator{{op, v}}, nil
+}
+
+func (c versionComparator) match(v Version) bool {
+	cmp := v.Compare(c.v)
+	switch c.op {
+	case "=":
+		return cmp == 0
+	case "!=":
+		return cmp != 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	}
+
+	return false
+}
+
+// Contains tells whether v is in the range. Like npm, a prerelease is only
+// in a range naming a prerelease of the same major, minor and patch.
+func (r VersionRange) Contains(v Version) bool {
+	for _, set := range r {
+		if matchSet(set, v) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func matchSet(set []versionComparator, v Version) bool {
+	for _, c := range set {
+		if !c.match(v) {
+			return false
+		}
+	}
+
+	if v.Prerelease == "" {
+		return true
+	}
+
+	for _, c := range set {
+		if c.v.Prerelease != "" && c.v.Major == v.Major && c.v.Minor == v.Minor && c.v.Patch == v.Patch {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,113 @@
+package dockerPull
+
+import (
+	"testing"
+)
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    Version
+		wantErr bool
+	}{
+		{"ParseVersion1", "1.2.3", Version{Major: 1, Minor: 2, Patch: 3}, false},
+		{"ParseVersion2", "v1.2", Version{Major: 1, Minor: 2}, false},
+		{"ParseVersion3", "3", Version{Major: 3}, false},
+		{"ParseVersion4", "1.2.3-rc.1+build.5", Version{Major: 1, Minor: 2, Patch: 3, Prerelease: "rc.1"}, false},
+		{"ParseVersion5", "1.2.3.4", Version{}, true},
+		{"ParseVersion6", "latest", Version{}, true},
+		{"ParseVersion7", "1.02", Version{}, true},
+		{"ParseVersion8", "1.2-", Version{}, true},
+		{"ParseVersion9", "1.x", Version{}, true},
+		{"ParseVersion10", "3.10-alpine", Version{Major: 3, Minor: 10, Prerelease: "alpine"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseVersion(tt.s)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseVersion() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseVersion() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVersion_Compare(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want int
+	}{
+		{"Compare1", "1.2.3", "1.2.3", 0},
+		{"Compare2", "1.2.3", "1.10.0", -1},
+		{"Compare3", "2.0.0", "1.99.99", 1},
+		{"Compare4", "1.0.0-rc.1", "1.0.0", -1},
+		{"Compare5", "1.0.0-alpha", "1.0.0-alpha.1", -1},
+		{"Compare6", "1.0.0-alpha.1", "1.0.0-alpha.beta", -1},
+		{"Compare7", "1.0.0-beta.11", "1.0.0-beta.2", 1},
+		{"Compare8", "1.0.0-rc.1", "1.0.0-beta.11", 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, _ := ParseVersion(tt.a)
+			b, _ := ParseVersion(tt.b)
+			if got := a.Compare(b); got != tt.want {
+				t.Errorf("Compare() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVersionRange_Contains(t *testing.T) {
+	tests := []struct {
+		name    string
+		r       string
+		v       string
+		want    bool
+		wantErr bool
+	}{
+		{"Contains1", ">=1.2.0 <2", "1.5.0", true, false},
+		{"Contains2", ">=1.2.0 <2", "2.0.0", false, false},
+		{"Contains3", ">= 1.2, < 2", "1.5.0", false, true},
+		{"Contains4", "^1.2", "1.9.9", true, false},
+		{"Contains5", "^1.2", "2.0.0", false, false},
+		{"Contains6", "^0.2.3", "0.2.9", true, false},
+		{"Contains7", "^0.2.3", "0.3.0", false, false},
+		{"Contains8", "~1.2.3", "1.2.9", true, false},
+		{"Contains9", "~1.2.3", "1.3.0", false, false},
+		{"Contains10", "1.2", "1.2.7", true, false},
+		{"Contains11", "1.2.x", "1.3.0", false, false},
+		{"Contains12", "<1.2 || >=3", "3.1.0", true, false},
+		{"Contains13", "<1.2 || >=3", "2.0.0", false, false},
+		{"Contains14", ">1.2", "1.2.9", false, false},
+		{"Contains15", "<=1.2", "1.2.9", true, false},
+		{"Contains16", ">=1.0.0", "2.0.0-rc.1", false, false},
+		{"Contains17", ">=2.0.0-rc.0", "2.0.0-rc.1", true, false},
+		{"Contains18", "*", "0.1.0", true, false},
+		{"Contains19", "!=1.2.3", "1.2.3", false, false},
+		{"Contains20", "=>1.2", "", false, true},
+		{"Contains21", "", "", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := ParseVersionRange(tt.r)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseVersionRange() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			v, err := ParseVersion(tt.v)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := r.Contains(v); got != tt.want {
+				t.Errorf("Contains() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
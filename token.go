@@ -0,0 +1,239 @@
+/*
+Copyright © 2021 myback.space <git@myback.space>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dockerPull
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultTokenExpiresIn is used when the token server omits expires_in,
+	// as the distribution token spec requires.
+	defaultTokenExpiresIn = 60
+	// tokenRefreshMargin makes a token stale a bit before it really expires,
+	// so a request never goes out with a token that expires in flight.
+	tokenRefreshMargin = 10 * time.Second
+)
+
+type jwtToken struct {
+	Token       string    `json:"token"`
+	AccessToken string    `json:"access_token"`
+	ExpiresIn   int       `json:"expires_in"`
+	IssuedAt    time.Time `json:"issued_at"`
+}
+
+func (t *jwtToken) expired() bool {
+	lifetime := time.Duration(t.ExpiresIn) * time.Second
+	margin := tokenRefreshMargin
+	if margin > lifetime/2 {
+		margin = lifetime / 2
+	}
+
+	return time.Now().After(t.IssuedAt.Add(lifetime - margin))
+}
+
+// authCache keeps the authentication challenge of every registry host and
+// the bearer tokens issued for it keyed by login, realm, service and
+// scope. It is shared by the clients of one RegistryClient and safe for
+// concurrent use. mu guards the maps only, a token request holds the lock
+// of its key.
+type authCache struct {
+	mu         sync.Mutex
+	challenges map[string]WWWAuthenticate
+	tokens     map[string]*jwtToken
+	tokenLocks map[string]*sync.Mutex
+}
+
+func newAuthCache() *authCache {
+	return &authCache{
+		challenges: map[string]WWWAuthenticate{},
+		tokens:     map[string]*jwtToken{},
+		tokenLocks: map[string]*sync.Mutex{},
+	}
+}
+
+func (ac *authCache) challenge(host string) (WWWAuthenticate, bool) {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+
+	ch, ok := ac.challenges[host]
+	return ch, ok
+}
+
+func (ac *authCache) setChallenge(host string, ch WWWAuthenticate) {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+
+	ac.challenges[host] = ch
+}
+
+func (ac *authCache) token(key string) (*jwtToken, bool) {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+
+	token, ok := ac.tokens[key]
+	return token, ok
+}
+
+func (ac *authCache) setToken(key string, token *jwtToken) {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+
+	ac.tokens[key] = token
+}
+
+// tokenLock returns the lock serializing token requests for key.
+func (ac *authCache) tokenLock(key string) *sync.Mutex {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+
+	l, ok := ac.tokenLocks[key]
+	if !ok {
+		l = &sync.Mutex{}
+		ac.tokenLocks[key] = l
+	}
+
+	return l
+}
+
+// tokenKey tells apart the tokens of the users sharing an authCache, e.g.
+// the source and the destination of a copy within one registry.
+func tokenKey(login string, ch WWWAuthenticate, scope string) string {
+	return strings.Join([]string{login, ch.Realm, ch.Service, scope}, " ")
+}
+
+// bearerToken returns a cached token for the challenge and scope, fetching a
+// new one if there is none, it is about to expire or refresh is set. The
+// lock of the key is held while fetching so concurrent layer downloads wait
+// for one token request instead of each making their own, requests for
+// other keys are not held up.
+func (c *Client) bearerToken(ctx context.Context, ch WWWAuthenticate, scope string, refresh bool) (string, error) {
+	key := tokenKey(c.login, ch, scope)
+
+	l := c.auth.tokenLock(key)
+	l.Lock()
+	defer l.Unlock()
+
+	if token, ok := c.auth.token(key); ok && !refresh && !token.expired() {
+		return token.Token, nil
+	}
+
+	token, err := c.getToken(ctx, ch, scope)
+	if err != nil {
+		return "", err
+	}
+	c.auth.setToken(key, token)
+
+	return token.Token, nil
+}
+
+// newTokenRequest builds a GET token request with basic auth, or the OAuth2
+// refresh_token grant POST when the client has an identity token.
+func (c *Client) newTokenRequest(ctx context.Context, ch WWWAuthenticate) (*http.Request, error) {
+	if c.identityToken == "" {
+		u, err := ch.Url("")
+		if err != nil {
+			return nil, err
+		}
+
+		req, err := c.NewGetRequestContext(ctx, u)
+		if err != nil {
+			return nil, err
+		}
+
+		if c.login != "" {
+			req.SetBasicAuth(c.login, c.password)
+		}
+
+		return req, nil
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "refresh_token")
+	form.Set("refresh_token", c.identityToken)
+	form.Set("client_id", c.userAgent())
+	form.Set("service", ch.Service)
+	if ch.Scope != "" {
+		form.Set("scope", ch.Scope)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", ch.Realm, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", c.userAgent())
+
+	return req, nil
+}
+
+// getToken requests a token for scope from the realm of the challenge.
+func (c *Client) getToken(ctx context.Context, ch WWWAuthenticate, scope string) (*jwtToken, error) {
+	if scope != "" {
+		ch.Scope = scope
+	}
+
+	req, err := c.newTokenRequest(ctx, ch)
+	if err != nil {
+		return nil, err
+	}
+
+	issuedAt := time.Now()
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		return nil, ErrUnauthorized
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("token request: status code [%d]: error: \"%s\"", resp.StatusCode, b)
+	}
+
+	token := &jwtToken{}
+	if err := json.NewDecoder(resp.Body).Decode(token); err != nil {
+		return nil, err
+	}
+
+	if token.Token == "" {
+		token.Token = token.AccessToken
+	}
+	if token.Token == "" {
+		return nil, fmt.Errorf("token request: empty token in response from %s", ch.Realm)
+	}
+	if token.ExpiresIn < defaultTokenExpiresIn {
+		token.ExpiresIn = defaultTokenExpiresIn
+	}
+	if token.IssuedAt.IsZero() {
+		token.IssuedAt = issuedAt
+	}
+
+	return token, nil
+}
@@ -0,0 +1,213 @@
+package dockerPull
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_jwtToken_expired(t *testing.T) {
+	tests := []struct {
+		name      string
+		expiresIn int
+		issuedAt  time.Duration
+		want      bool
+	}{
+		{"expired1", 300, 0, false},
+		{"expired2", 300, -289 * time.Second, false},
+		{"expired3", 300, -291 * time.Second, true},
+		{"expired4", 10, -4 * time.Second, false},
+		{"expired5", 10, -6 * time.Second, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := &jwtToken{ExpiresIn: tt.expiresIn, IssuedAt: time.Now().Add(tt.issuedAt)}
+			if got := token.expired(); got != tt.want {
+				t.Errorf("expired() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// tokenServer answers token requests with response and counts them by scope.
+type tokenServer struct {
+	mu       sync.Mutex
+	requests map[string]int
+	response map[string]interface{}
+	waiting  chan struct{}
+	block    chan struct{}
+}
+
+func (ts *tokenServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if ts.block != nil {
+		ts.waiting <- struct{}{}
+		<-ts.block
+	}
+
+	ts.mu.Lock()
+	ts.requests[r.URL.Query().Get("scope")]++
+	ts.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(ts.response)
+}
+
+func (ts *tokenServer) count(scope string) int {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	return ts.requests[scope]
+}
+
+func newTokenServer(t *testing.T, response map[string]interface{}) (*tokenServer, WWWAuthenticate) {
+	ts := &tokenServer{requests: map[string]int{}, response: response}
+	srv := httptest.NewServer(ts)
+	t.Cleanup(srv.Close)
+
+	return ts, WWWAuthenticate{Scheme: "Bearer", Realm: srv.URL + "/token", Service: "registry.test"}
+}
+
+func newTokenClient() *Client {
+	return &Client{
+		Client: (&RegistryClient{CertsDirs: []string{}}).httpClient(""),
+		Image:  &requestedImage{registryHost: "registry.test", ns: "test", tag: "latest"},
+		auth:   newAuthCache(),
+	}
+}
+
+func TestClient_bearerToken(t *testing.T) {
+	const scope = "repository:test:pull"
+
+	ts, ch := newTokenServer(t, map[string]interface{}{"token": "abc", "expires_in": 300})
+	c := newTokenClient()
+
+	for i := 0; i < 3; i++ {
+		token, err := c.bearerToken(context.Background(), ch, scope, false)
+		if err != nil || token != "abc" {
+			t.Fatalf("bearerToken() = %q, %v, want abc", token, err)
+		}
+	}
+	if n := ts.count(scope); n != 1 {
+		t.Errorf("bearerToken() made %d token requests, want 1", n)
+	}
+
+	if _, err := c.bearerToken(context.Background(), ch, "repository:other:pull", false); err != nil {
+		t.Fatal(err)
+	}
+	if n := ts.count("repository:other:pull"); n != 1 {
+		t.Errorf("bearerToken() made %d token requests for another scope, want 1", n)
+	}
+
+	if _, err := c.bearerToken(context.Background(), ch, scope, true); err != nil {
+		t.Fatal(err)
+	}
+	if n := ts.count(scope); n != 2 {
+		t.Errorf("bearerToken() made %d token requests after refresh, want 2", n)
+	}
+
+	// A token within the refresh margin of its expiry is fetched again.
+	c.auth.tokens[tokenKey(c.login, ch, scope)].IssuedAt = time.Now().Add(-295 * time.Second)
+	if _, err := c.bearerToken(context.Background(), ch, scope, false); err != nil {
+		t.Fatal(err)
+	}
+	if n := ts.count(scope); n != 3 {
+		t.Errorf("bearerToken() made %d token requests after expiry, want 3", n)
+	}
+}
+
+func TestClient_bearerTokenConcurrent(t *testing.T) {
+	slow, slowCh := newTokenServer(t, map[string]interface{}{"token": "slow"})
+	slow.waiting = make(chan struct{})
+	slow.block = make(chan struct{})
+	defer close(slow.block)
+
+	_, fastCh := newTokenServer(t, map[string]interface{}{"token": "fast"})
+
+	c := newTokenClient()
+	go c.bearerToken(context.Background(), slowCh, "repository:test:pull", false)
+	<-slow.waiting
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := c.bearerToken(context.Background(), fastCh, "repository:test:pull", false)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("bearerToken() of one realm waits for the token request of another")
+	}
+}
+
+func TestClient_getToken(t *testing.T) {
+	issuedAt := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name          string
+		response      map[string]interface{}
+		wantToken     string
+		wantExpiresIn int
+		wantIssuedAt  bool
+		wantErr       bool
+	}{
+		{"getToken1", map[string]interface{}{"token": "abc", "expires_in": 300, "issued_at": issuedAt}, "abc", 300, true, false},
+		{"getToken2", map[string]interface{}{"access_token": "abc", "expires_in": 300}, "abc", 300, false, false},
+		{"getToken3", map[string]interface{}{"token": "abc"}, "abc", defaultTokenExpiresIn, false, false},
+		{"getToken4", map[string]interface{}{"token": "abc", "expires_in": 10}, "abc", defaultTokenExpiresIn, false, false},
+		{"getToken5", map[string]interface{}{"expires_in": 300}, "", 0, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, ch := newTokenServer(t, tt.response)
+
+			token, err := newTokenClient().getToken(context.Background(), ch, "repository:test:pull")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("getToken() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if token.Token != tt.wantToken || token.ExpiresIn != tt.wantExpiresIn {
+				t.Errorf("getToken() = %q expiring in %d, want %q expiring in %d", token.Token, token.ExpiresIn, tt.wantToken, tt.wantExpiresIn)
+			}
+			if token.IssuedAt.Equal(issuedAt) != tt.wantIssuedAt || token.IssuedAt.IsZero() {
+				t.Errorf("getToken() issued at %s", token.IssuedAt)
+			}
+		})
+	}
+}
+
+func TestClient_bearerTokenPerLogin(t *testing.T) {
+	const scope = "repository:test:pull"
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, _, _ := r.BasicAuth()
+		json.NewEncoder(w).Encode(map[string]string{"token": "token of " + user})
+	}))
+	defer srv.Close()
+	ch := WWWAuthenticate{Scheme: "Bearer", Realm: srv.URL + "/token", Service: "registry.test"}
+
+	auth := newAuthCache()
+	for _, login := range []string{"reader", "writer", "reader"} {
+		c := newTokenClient()
+		c.auth = auth
+		c.login, c.password = login, "secret"
+
+		token, err := c.bearerToken(context.Background(), ch, scope, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "token of " + login; token != want {
+			t.Errorf("bearerToken() of %s = %q, want %q", login, token, want)
+		}
+	}
+}
@@ -0,0 +1,343 @@
+/*
+Copyright © 2021 myback.space <git@myback.space>
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dockerPull
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/docker/distribution"
+	"github.com/opencontainers/go-digest"
+)
+
+// BlobExists reports whether the repository has the blob, a HEAD request
+// answered 200 or 404.
+func (c *Client) BlobExists(dgst digest.Digest) (bool, error) {
+	return c.BlobExistsContext(context.Background(), dgst)
+}
+
+func (c *Client) BlobExistsContext(ctx context.Context, dgst digest.Digest) (bool, error) {
+	var exists bool
+	err := c.retry(ctx, func() error {
+		req, err := c.newRequest(ctx, http.MethodHead, c.Image.BlobUrl(dgst.String()), nil)
+		if err != nil {
+			return err
+		}
+
+		resp, err := c.do(req, c.pushScope())
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		switch resp.StatusCode {
+		case http.StatusOK:
+			exists = true
+		case http.StatusNotFound:
+			exists = false
+		default:
+			return newErrHTTPStatus(resp)
+		}
+
+		return nil
+	}, nil)
+
+	return exists, err
+}
+
+// MountBlob asks the registry to link the blob of the repository from, a
+// path in the same registry like library/alpine, into the repository of the
+// client instead of uploading it. It reports false when the registry did
+// not mount the blob, e.g. when from does not have it or the user may not
+// pull from there.
+func (c *Client) MountBlob(dgst digest.Digest, from string) (bool, error) {
+	return c.MountBlobContext(context.Background(), dgst, from)
+}
+
+func (c *Client) MountBlobContext(ctx context.Context, dgst digest.Digest, from string) (bool, error) {
+	location, mounted, err := c.mountBlob(ctx, dgst, from)
+
+	// The registry started an upload instead, which is not needed.
+	if location != nil {
+		c.cancelUpload(ctx, location)
+	}
+
+	return mounted, err
+}
+
+// mountBlob is MountBlobContext leaving the upload session the registry
+// opens for a failed mount to the caller, location is nil otherwise.
+func (c *Client) mountBlob(ctx context.Context, dgst digest.Digest, from string) (location *url.URL, mounted bool, err error) {
+	err = c.retry(ctx, func() error {
+		q := url.Values{}
+		q.Set("mount", dgst.String())
+		q.Set("from", from)
+
+		location, mounted, err = c.startUpload(ctx, q, c.pushScope()+" repository:"+from+":pull")
+		return err
+	}, nil)
+
+	return location, mounted, err
+}
+
+// UploadBlob uploads the blob desc read from r. The blob is sent with a
+// single PUT when ChunkSize is 0, otherwise in PATCH requests of ChunkSize
+// bytes followed by the closing PUT. A failed upload is repeated only when
+// r is an io.Seeker, which is rewound to its starting offset.
+func (c *Client) UploadBlob(desc distribution.Descriptor, r io.Reader) error {
+	return c.UploadBlobContext(context.Background(), desc, r)
+}
+
+func (c *Client) UploadBlobContext(ctx context.Context, desc distribution.Descriptor, r io.Reader) error {
+	return c.uploadBlob(ctx, desc, r, nil)
+}
+
+// uploadBlob is UploadBlobContext sending the blob through the upload
+// session at location, e.g. the one of a failed mount, when it is set. A
+// repeated upload opens a new session.
+func (c *Client) uploadBlob(ctx context.Context, desc distribution.Descriptor, r io.Reader, location *url.URL) error {
+	upload := func() error {
+		var err error
+		if location == nil {
+			if location, _, err = c.startUpload(ctx, nil, c.pushScope()); err != nil {
+				return err
+			}
+		}
+
+		if c.ChunkSize > 0 {
+			err = c.uploadChunks(ctx, location, desc, r)
+		} else {
+			err = c.completeUpload(ctx, location, desc, r)
+		}
+
+		// A failed upload is not left open on the registry, a retry
+		// starts a new one.
+		if err != nil {
+			c.cancelUpload(ctx, location)
+			location = nil
+		}
+
+		return err
+	}
+
+	seeker, ok := r.(io.Seeker)
+	if !ok {
+		return upload()
+	}
+
+	start, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	return c.retry(ctx, func() error {
+		if _, err := seeker.Seek(start, io.SeekStart); err != nil {
+			return err
+		}
+
+		return upload()
+	}, nil)
+}
+
+// PutManifest stores the manifest payload under ref, a tag or the digest
+// of the payload, and returns the digest the registry computed.
+func (c *Client) PutManifest(ref, mediaType string, payload []byte) (digest.Digest, error) {
+	return c.PutManifestContext(context.Background(), ref, mediaType, payload)
+}
+
+func (c *Client) PutManifestContext(ctx context.Context, ref, mediaType string, payload []byte) (digest.Digest, error) {
+	dgst := digest.FromBytes(payload)
+	err := c.retry(ctx, func() error {
+		req, err := c.newRequest(ctx, http.MethodPut, c.Image.ManifestUrl(ref), bytes.NewReader(payload))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", mediaType)
+
+		resp, err := c.do(req, c.pushScope())
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusCreated {
+			return newErrHTTPStatus(resp)
+		}
+
+		if d, err := digest.Parse(resp.Header.Get("Docker-Content-Digest")); err == nil {
+			dgst = d
+		}
+
+		return nil
+	}, nil)
+
+	return dgst, err
+}
+
+func (c *Client) pushScope() string {
+	return c.Image.Scope("pull", "push")
+}
+
+// startUpload opens an upload session with query, the registry answers 202
+// and the Location to send the blob to. A mount request may be answered 201
+// instead, then the blob is in the repository already and ok is true.
+func (c *Client) startUpload(ctx context.Context, query url.Values, scope string) (location *url.URL, ok bool, err error) {
+	u, err := url.Parse(c.Image.Url("blobs", "uploads") + "/")
+	if err != nil {
+		return nil, false, err
+	}
+	u.RawQuery = query.Encode()
+
+	req, err := c.newRequest(ctx, http.MethodPost, u.String(), nil)
+	if err != nil {
+		return nil, false, err
+	}
+
+	resp, err := c.do(req, scope)
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusCreated:
+		return nil, true, nil
+	case http.StatusAccepted:
+		location, err := uploadLocation(resp)
+		return location, false, err
+	}
+
+	return nil, false, newErrHTTPStatus(resp)
+}
+
+// uploadChunks sends the blob in PATCH requests of ChunkSize bytes, each
+// answered with the Location of the next one, and closes the upload.
+// location is updated to the latest one, which a cancel is sent to.
+func (c *Client) uploadChunks(ctx context.Context, location *url.URL, desc distribution.Descriptor, r io.Reader) error {
+	buf := make([]byte, c.ChunkSize)
+	var offset int64
+	for {
+		n, err := io.ReadFull(r, buf)
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return err
+		}
+
+		req, err := c.newRequest(ctx, http.MethodPatch, location.String(), bytes.NewReader(buf[:n]))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/octet-stream")
+		req.Header.Set("Content-Range", fmt.Sprintf("%d-%d", offset, offset+int64(n)-1))
+
+		resp, err := c.do(req, c.pushScope())
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusAccepted {
+			return newErrHTTPStatus(resp)
+		}
+		next, err := uploadLocation(resp)
+		if err != nil {
+			return err
+		}
+		*location = *next
+		offset += int64(n)
+
+		if n < len(buf) {
+			break
+		}
+	}
+
+	if desc.Size > 0 && offset != desc.Size {
+		return fmt.Errorf("blob %s: uploaded %d bytes, expected %d", desc.Digest, offset, desc.Size)
+	}
+
+	return c.completeUpload(ctx, location, desc, nil)
+}
+
+// completeUpload closes the upload at location with a PUT carrying the
+// digest and the rest of the blob read from r, which may be nil.
+func (c *Client) completeUpload(ctx context.Context, location *url.URL, desc distribution.Descriptor, r io.Reader) error {
+	u := *location
+	q := u.Query()
+	q.Set("digest", desc.Digest.String())
+	u.RawQuery = q.Encode()
+
+	// The transport closes the body, r stays open for a repeated upload.
+	var body io.Reader = http.NoBody
+	if r != nil {
+		body = ioutil.NopCloser(r)
+	}
+
+	req, err := c.newRequest(ctx, http.MethodPut, u.String(), body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if r == nil {
+		req.ContentLength = 0
+	} else if desc.Size > 0 {
+		req.ContentLength = desc.Size
+	}
+
+	resp, err := c.do(req, c.pushScope())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return newErrHTTPStatus(resp)
+	}
+
+	return nil
+}
+
+// cancelUpload deletes an upload session, a failure only leaves it to the
+// registry to expire.
+func (c *Client) cancelUpload(ctx context.Context, location *url.URL) {
+	req, err := c.newRequest(ctx, http.MethodDelete, location.String(), nil)
+	if err != nil {
+		return
+	}
+
+	if resp, err := c.do(req, c.pushScope()); err == nil {
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+	}
+}
+
+// uploadLocation resolves the Location of an upload answer, which may be
+// relative to the request, keeping its query like the state of the upload.
+func uploadLocation(resp *http.Response) (*url.URL, error) {
+	loc := resp.Header.Get("Location")
+	if loc == "" {
+		return nil, fmt.Errorf("%s %s: status code [%d] without Location", resp.Request.Method, resp.Request.URL.Path, resp.StatusCode)
+	}
+
+	return resp.Request.URL.Parse(loc)
+}
@@ -0,0 +1,395 @@
+package dockerPull
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/docker/distribution"
+	"github.com/opencontainers/go-digest"
+)
+
+// testRegistry is an in-memory registry serving the upload protocol behind
+// bearer authentication.
+type testRegistry struct {
+	*httptest.Server
+
+	mu        sync.Mutex
+	blobs     map[string]map[digest.Digest][]byte
+	manifests map[string]map[string][]byte
+	types     map[string]map[string]string
+	uploads   map[string][]byte
+	scopes    [][]string
+	users     []string
+	requests  map[string]int
+	mounts    int
+	next      int
+}
+
+func newTestRegistry() *testRegistry {
+	reg := &testRegistry{
+		blobs:     map[string]map[digest.Digest][]byte{},
+		manifests: map[string]map[string][]byte{},
+		types:     map[string]map[string]string{},
+		uploads:   map[string][]byte{},
+		requests:  map[string]int{},
+	}
+	reg.Server = httptest.NewServer(http.HandlerFunc(reg.serve))
+
+	return reg
+}
+
+func (reg *testRegistry) client(repo string, chunkSize int64) *Client {
+	u, _ := url.Parse(reg.URL)
+
+	return &Client{
+		Client:    (&RegistryClient{CertsDirs: []string{}}).httpClient(""),
+		Image:     &requestedImage{registryHost: u.Host, ns: repo, tag: "latest"},
+		Retry:     RetryPolicy{Attempts: 1},
+		ChunkSize: chunkSize,
+	}
+}
+
+func (reg *testRegistry) addBlob(repo string, b []byte) digest.Digest {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	dgst := digest.FromBytes(b)
+	if reg.blobs[repo] == nil {
+		reg.blobs[repo] = map[digest.Digest][]byte{}
+	}
+	reg.blobs[repo][dgst] = b
+
+	return dgst
+}
+
+func (reg *testRegistry) addManifest(repo, ref, mediaType string, b []byte) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	if reg.manifests[repo] == nil {
+		reg.manifests[repo] = map[string][]byte{}
+		reg.types[repo] = map[string]string{}
+	}
+	reg.manifests[repo][ref] = b
+	reg.types[repo][ref] = mediaType
+}
+
+func (reg *testRegistry) blob(repo string, dgst digest.Digest) ([]byte, bool) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	b, ok := reg.blobs[repo][dgst]
+	return b, ok
+}
+
+func (reg *testRegistry) serve(w http.ResponseWriter, r *http.Request) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	if r.URL.Path == "/token" {
+		reg.scopes = append(reg.scopes, r.URL.Query()["scope"])
+		user, _, _ := r.BasicAuth()
+		reg.users = append(reg.users, user)
+		json.NewEncoder(w).Encode(map[string]string{"token": "push"})
+		return
+	}
+
+	if r.Header.Get("Authorization") != "Bearer push" {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="test"`, reg.URL))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	reg.requests[r.Method]++
+
+	path := strings.TrimPrefix(r.URL.Path, "/v2/")
+	switch {
+	case strings.Contains(path, "/blobs/uploads/"):
+		i := strings.Index(path, "/blobs/uploads/")
+		reg.serveUpload(w, r, path[:i], path[i+len("/blobs/uploads/"):])
+	case strings.Contains(path, "/blobs/"):
+		i := strings.Index(path, "/blobs/")
+		b, ok := reg.blobs[path[:i]][digest.Digest(path[i+len("/blobs/"):])]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+		w.Write(b)
+	case strings.Contains(path, "/manifests/") && r.Method == http.MethodGet:
+		i := strings.Index(path, "/manifests/")
+		repo, ref := path[:i], path[i+len("/manifests/"):]
+		b, ok := reg.manifests[repo][ref]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", reg.types[repo][ref])
+		w.Write(b)
+	case strings.Contains(path, "/manifests/") && r.Method == http.MethodPut:
+		i := strings.Index(path, "/manifests/")
+		repo, ref := path[:i], path[i+len("/manifests/"):]
+		b, _ := ioutil.ReadAll(r.Body)
+		if reg.manifests[repo] == nil {
+			reg.manifests[repo] = map[string][]byte{}
+			reg.types[repo] = map[string]string{}
+		}
+		reg.manifests[repo][ref] = b
+		reg.types[repo][ref] = r.Header.Get("Content-Type")
+		w.Header().Set("Docker-Content-Digest", digest.FromBytes(b).String())
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (reg *testRegistry) serveUpload(w http.ResponseWriter, r *http.Request, repo, id string) {
+	q := r.URL.Query()
+	switch r.Method {
+	case http.MethodPost:
+		if from := q.Get("from"); from != "" {
+			if b, ok := reg.blobs[from][digest.Digest(q.Get("mount"))]; ok {
+				if reg.blobs[repo] == nil {
+					reg.blobs[repo] = map[digest.Digest][]byte{}
+				}
+				reg.blobs[repo][digest.Digest(q.Get("mount"))] = b
+				reg.mounts++
+				w.WriteHeader(http.StatusCreated)
+				return
+			}
+		}
+
+		reg.next++
+		id = strconv.Itoa(reg.next)
+		reg.uploads[id] = nil
+	case http.MethodPatch:
+		data, ok := reg.uploads[id]
+		if !ok || q.Get("_state") != id {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		var start, end int
+		if _, err := fmt.Sscanf(r.Header.Get("Content-Range"), "%d-%d", &start, &end); err != nil || start != len(data) {
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		b, _ := ioutil.ReadAll(r.Body)
+		reg.uploads[id] = append(data, b...)
+	case http.MethodPut:
+		data, ok := reg.uploads[id]
+		if !ok || q.Get("_state") != id {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		b, _ := ioutil.ReadAll(r.Body)
+		data = append(data, b...)
+		if digest.FromBytes(data).String() != q.Get("digest") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		delete(reg.uploads, id)
+		if reg.blobs[repo] == nil {
+			reg.blobs[repo] = map[digest.Digest][]byte{}
+		}
+		reg.blobs[repo][digest.Digest(q.Get("digest"))] = data
+		w.WriteHeader(http.StatusCreated)
+		return
+	case http.MethodDelete:
+		delete(reg.uploads, id)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	// A relative Location keeping the state of the upload in its query.
+	w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/uploads/%s?_state=%s", repo, id, id))
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func TestClient_UploadBlob(t *testing.T) {
+	data := []byte("0123456789")
+	desc := distribution.Descriptor{Digest: digest.FromBytes(data), Size: int64(len(data))}
+
+	tests := []struct {
+		name      string
+		chunkSize int64
+		patches   int
+	}{
+		{"UploadBlob1", 0, 0},
+		{"UploadBlob2", 3, 4},
+		{"UploadBlob3", 5, 2},
+		{"UploadBlob4", 100, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := newTestRegistry()
+			defer reg.Close()
+
+			c := reg.client("team/app", tt.chunkSize)
+			if err := c.UploadBlobContext(context.Background(), desc, bytes.NewReader(data)); err != nil {
+				t.Fatal(err)
+			}
+
+			if got, _ := reg.blob("team/app", desc.Digest); !bytes.Equal(got, data) {
+				t.Errorf("uploaded %q, want %q", got, data)
+			}
+			if reg.requests[http.MethodPatch] != tt.patches {
+				t.Errorf("got %d PATCH requests, want %d", reg.requests[http.MethodPatch], tt.patches)
+			}
+			if len(reg.uploads) != 0 {
+				t.Errorf("%d uploads left open", len(reg.uploads))
+			}
+			if want := []string{"repository:team/app:pull,push"}; len(reg.scopes) != 1 || fmt.Sprint(reg.scopes[0]) != fmt.Sprint(want) {
+				t.Errorf("token scopes %v, want %v", reg.scopes, want)
+			}
+		})
+	}
+}
+
+func TestClient_UploadBlobCancel(t *testing.T) {
+	tests := []struct {
+		name      string
+		chunkSize int64
+	}{
+		{"UploadBlobCancel1", 0},
+		{"UploadBlobCancel2", 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := newTestRegistry()
+			defer reg.Close()
+
+			blob := []byte("0123456789")
+			desc := distribution.Descriptor{Digest: digest.FromString("other"), Size: int64(len(blob))}
+
+			c := reg.client("test", tt.chunkSize)
+			if err := c.UploadBlobContext(context.Background(), desc, bytes.NewReader(blob)); err == nil {
+				t.Fatal("UploadBlob() of a blob not matching its digest succeeded")
+			}
+
+			if len(reg.uploads) != 0 || reg.requests[http.MethodDelete] != 1 {
+				t.Errorf("UploadBlob() left uploads %v open, %d cancelled", reg.uploads, reg.requests[http.MethodDelete])
+			}
+		})
+	}
+}
+
+func TestClient_MountBlob(t *testing.T) {
+	reg := newTestRegistry()
+	defer reg.Close()
+
+	base := reg.addBlob("library/base", []byte("base layer"))
+	other := digest.FromString("missing")
+
+	tests := []struct {
+		name    string
+		dgst    digest.Digest
+		from    string
+		mounted bool
+	}{
+		{"MountBlob1", base, "library/base", true},
+		{"MountBlob2", other, "library/base", false},
+		{"MountBlob3", base, "library/other", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := reg.client("team/app", 0)
+			mounted, err := c.MountBlobContext(context.Background(), tt.dgst, tt.from)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if mounted != tt.mounted {
+				t.Errorf("MountBlob() = %v, want %v", mounted, tt.mounted)
+			}
+
+			exists, err := c.BlobExistsContext(context.Background(), tt.dgst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if exists != (tt.dgst == base) {
+				t.Errorf("BlobExists() = %v, want %v", exists, tt.dgst == base)
+			}
+			if len(reg.uploads) != 0 {
+				t.Errorf("%d uploads left open", len(reg.uploads))
+			}
+		})
+	}
+
+	want := "[repository:team/app:pull,push repository:library/base:pull]"
+	if len(reg.scopes) == 0 || fmt.Sprint(reg.scopes[0]) != want {
+		t.Errorf("token scopes %v, want %s first", reg.scopes, want)
+	}
+}
+
+func TestClient_uploadBlobMountFailed(t *testing.T) {
+	tests := []struct {
+		name      string
+		chunkSize int64
+	}{
+		{"uploadBlobMountFailed1", 0},
+		{"uploadBlobMountFailed2", 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reg := newTestRegistry()
+			defer reg.Close()
+
+			blob := []byte("0123456789")
+			desc := distribution.Descriptor{Digest: digest.FromBytes(blob), Size: int64(len(blob))}
+
+			c := reg.client("team/app", tt.chunkSize)
+			location, mounted, err := c.mountBlob(context.Background(), desc.Digest, "library/base")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if mounted || location == nil {
+				t.Fatalf("mountBlob() = %v, %v, want an upload session", location, mounted)
+			}
+
+			if err := c.uploadBlob(context.Background(), desc, bytes.NewReader(blob), location); err != nil {
+				t.Fatal(err)
+			}
+			if b, ok := reg.blob("team/app", desc.Digest); !ok || !bytes.Equal(b, blob) {
+				t.Errorf("blob = %q, want %q", b, blob)
+			}
+			if reg.requests[http.MethodPost] != 1 || reg.requests[http.MethodDelete] != 0 {
+				t.Errorf("got %d POST and %d DELETE requests, want 1 and 0", reg.requests[http.MethodPost], reg.requests[http.MethodDelete])
+			}
+			if len(reg.uploads) != 0 {
+				t.Errorf("%d uploads left open", len(reg.uploads))
+			}
+		})
+	}
+}
+
+func TestClient_PutManifest(t *testing.T) {
+	reg := newTestRegistry()
+	defer reg.Close()
+
+	payload := []byte(`{"schemaVersion":2}`)
+	c := reg.client("team/app", 0)
+	dgst, err := c.PutManifestContext(context.Background(), "1.0", "application/vnd.oci.image.manifest.v1+json", payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if dgst != digest.FromBytes(payload) {
+		t.Errorf("PutManifest() = %s, want %s", dgst, digest.FromBytes(payload))
+	}
+	if !bytes.Equal(reg.manifests["team/app"]["1.0"], payload) {
+		t.Errorf("stored %q, want %q", reg.manifests["team/app"]["1.0"], payload)
+	}
+	if reg.types["team/app"]["1.0"] != "application/vnd.oci.image.manifest.v1+json" {
+		t.Errorf("stored media type %q", reg.types["team/app"]["1.0"])
+	}
+}
@@ -17,27 +17,68 @@ limitations under the License.
 package dockerPull
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"io"
 	"net/url"
 	"os"
-	"reflect"
 	"strings"
 )
 
 type WWWAuthenticate struct {
-	Realm, Service, Scope string
+	Scheme, Realm, Service, Scope string
 }
 
+// WWWAuthenticateParse parses a challenge like
+// Bearer realm="https://auth.docker.io/token",service="registry.docker.io".
+// Values may be quoted and contain commas, unknown parameters are ignored.
 func WWWAuthenticateParse(s string) (out WWWAuthenticate) {
-	headerParts := strings.SplitN(s, " ", 2)
+	headerParts := strings.SplitN(strings.TrimSpace(s), " ", 2)
+	out.Scheme = headerParts[0]
+	if len(headerParts) < 2 {
+		return out
+	}
 
-	outs := reflect.ValueOf(&out).Elem()
-	for _, part := range strings.Split(headerParts[1], ",") {
-		kv := strings.SplitN(part, "=", 2)
-		outs.FieldByName(strings.Title(kv[0])).SetString(strings.ReplaceAll(kv[1], "\"", ""))
+	params := headerParts[1]
+	for params != "" {
+		kv := strings.SplitN(params, "=", 2)
+		if len(kv) < 2 {
+			break
+		}
+		key := strings.ToLower(strings.TrimSpace(kv[0]))
+		params = strings.TrimLeft(kv[1], " ")
+
+		var value string
+		if strings.HasPrefix(params, "\"") {
+			end := strings.IndexByte(params[1:], '"')
+			if end == -1 {
+				end = len(params) - 1
+			}
+			value = params[1 : end+1]
+			params = params[end+1:]
+			if params != "" {
+				params = params[1:]
+			}
+		} else {
+			end := strings.IndexByte(params, ',')
+			if end == -1 {
+				end = len(params)
+			}
+			value = strings.TrimSpace(params[:end])
+			params = params[end:]
+		}
+		params = strings.TrimLeft(params, ", ")
+
+		switch key {
+		case "realm":
+			out.Realm = value
+		case "service":
+			out.Service = value
+		case "scope":
+			out.Scope = value
+		}
 	}
 
 	return out
@@ -46,30 +87,73 @@ func WWWAuthenticateParse(s string) (out WWWAuthenticate) {
 func (www *WWWAuthenticate) Url(action string) (string, error) {
 	u, err := url.Parse(www.Realm)
 	if err != nil {
-		return "", nil
+		return "", err
 	}
 	q, err := url.ParseQuery(u.RawQuery)
 	if err != nil {
-		return "", nil
+		return "", err
 	}
 
-	q.Set("service", www.Service)
+	if www.Service != "" {
+		q.Set("service", www.Service)
+	}
 	if www.Scope != "" {
 		if action != "" {
 			scope := strings.Split(www.Scope, ":")
 			if len(scope) == 3 {
-				scope[3] = action
+				scope[2] = action
 				www.Scope = strings.Join(scope, ":")
 			}
 		}
 
-		q.Set("scope", www.Scope)
+		// Several scopes, e.g. of a cross-repository blob mount, are
+		// separate parameters.
+		q.Del("scope")
+		for _, scope := range strings.Fields(www.Scope) {
+			q.Add("scope", scope)
+		}
 	}
 	u.RawQuery = q.Encode()
 
 	return u.String(), nil
 }
 
+// contextWriter fails writes once ctx is done. Added to an io.MultiWriter it
+// makes a long local copy stop on cancellation.
+type contextWriter struct {
+	ctx context.Context
+}
+
+func (w contextWriter) Write(p []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// copyFile copies src to a new inode at dst.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+
+	return out.Close()
+}
+
 func FileHashEqual(filename, hash string) (bool, error) {
 	f, err := os.Open(filename)
 	if err != nil {
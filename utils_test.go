@@ -0,0 +1,31 @@
+package dockerPull
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestWWWAuthenticateParse(t *testing.T) {
+	type args struct {
+		s string
+	}
+	tests := []struct {
+		name string
+		args args
+		want WWWAuthenticate
+	}{
+		{"WWWAuthenticateParse1", args{`Bearer realm="https://auth.docker.io/token",service="registry.docker.io"`}, WWWAuthenticate{Scheme: "Bearer", Realm: "https://auth.docker.io/token", Service: "registry.docker.io"}},
+		{"WWWAuthenticateParse2", args{`Bearer realm="https://auth.docker.io/token",service="registry.docker.io",scope="repository:library/alpine:pull,push"`}, WWWAuthenticate{Scheme: "Bearer", Realm: "https://auth.docker.io/token", Service: "registry.docker.io", Scope: "repository:library/alpine:pull,push"}},
+		{"WWWAuthenticateParse3", args{`Bearer realm="https://r.local/token",service="r.local",scope="repository:ns/img:pull",error="insufficient_scope"`}, WWWAuthenticate{Scheme: "Bearer", Realm: "https://r.local/token", Service: "r.local", Scope: "repository:ns/img:pull"}},
+		{"WWWAuthenticateParse4", args{`Basic realm="Registry Realm"`}, WWWAuthenticate{Scheme: "Basic", Realm: "Registry Realm"}},
+		{"WWWAuthenticateParse5", args{`Bearer realm=https://r.local/token, service=r.local`}, WWWAuthenticate{Scheme: "Bearer", Realm: "https://r.local/token", Service: "r.local"}},
+		{"WWWAuthenticateParse6", args{`Basic`}, WWWAuthenticate{Scheme: "Basic"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := WWWAuthenticateParse(tt.args.s); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("WWWAuthenticateParse() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}